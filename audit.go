@@ -0,0 +1,350 @@
+package injector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The decisions of the admission responses.
+const (
+	DecisionAllowed = "allowed"
+	DecisionPatched = "patched"
+	DecisionDenied  = "denied"
+	DecisionError   = "error"
+)
+
+// AuditRecord is the audit record of a mutation decision.
+type AuditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	UID       types.UID `json:"uid"`
+	User      string    `json:"user"`
+	Operation string    `json:"operation"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+
+	// Name is the name of the mutated object, or its generate name if the name isn't assigned yet.
+	Name string `json:"name"`
+
+	// TemplateVersion is the version of the sidecar template that the object is patched with. PreviousTemplateVersion is the version that the object was injected with before an update.
+	TemplateVersion         string `json:"templateVersion,omitempty"`
+	PreviousTemplateVersion string `json:"previousTemplateVersion,omitempty"`
+
+	Decision string          `json:"decision"`
+	Reason   string          `json:"reason,omitempty"`
+	Patch    json.RawMessage `json:"patch,omitempty"`
+}
+
+// AuditSink records the mutation decisions of the webhook. Sinks are called synchronously by the webhook, so slow sinks should be wrapped with NewAsyncAuditSink.
+type AuditSink interface {
+	Record(record *AuditRecord) error
+}
+
+// Decision returns the decision of the admission response of review.
+func Decision(review *admissionv1beta1.AdmissionReview) string {
+	response := review.Response
+	switch {
+	case response == nil:
+		return DecisionError
+	case response.Allowed && len(response.Patch) > 0:
+		return DecisionPatched
+	case response.Allowed:
+		return DecisionAllowed
+	case response.Result != nil && response.Result.Reason == metav1.StatusReasonForbidden:
+		return DecisionDenied
+	}
+
+	return DecisionError
+}
+
+// audit records the mutation decision of review with all the audit sinks. Sink failures are logged, and never fail the admission request.
+func (w *Webhook) audit(review *admissionv1beta1.AdmissionReview) {
+	if len(w.AuditSinks) == 0 || review.Request == nil {
+		return
+	}
+
+	record := newAuditRecord(review)
+	for _, sink := range w.AuditSinks {
+		if err := sink.Record(record); err != nil {
+			w.logger.Errorf("Failed to record audit record of request %s. Reason: %s", record.UID, err)
+		}
+	}
+}
+
+func newAuditRecord(review *admissionv1beta1.AdmissionReview) *AuditRecord {
+	request := review.Request
+	record := &AuditRecord{
+		Timestamp: time.Now().UTC(),
+		UID:       request.UID,
+		User:      request.UserInfo.Username,
+		Operation: string(request.Operation),
+		Kind:      request.Kind.Kind,
+		Namespace: request.Namespace,
+		Name:      request.Name,
+		Decision:  Decision(review),
+	}
+
+	var object struct {
+		metav1.ObjectMeta `json:"metadata"`
+	}
+	if record.Name == "" && json.Unmarshal(request.Object.Raw, &object) == nil {
+		record.Name = object.GenerateName
+	}
+
+	if len(request.OldObject.Raw) > 0 {
+		if template, _, err := podTemplate(request.Kind, request.OldObject.Raw); err == nil {
+			record.PreviousTemplateVersion = template.ObjectMeta.GetAnnotations()[annotationKeySidecarVersion]
+		}
+	}
+
+	if response := review.Response; response != nil {
+		if response.Result != nil {
+			record.Reason = response.Result.Message
+		}
+
+		if len(response.Patch) > 0 {
+			record.Patch = json.RawMessage(RedactJSON(response.Patch))
+			record.TemplateVersion = patchedVersion(response.Patch)
+		}
+	}
+
+	return record
+}
+
+// patchedVersion returns the sidecar template version that the JSON patch annotates the object with.
+func patchedVersion(patch []byte) string {
+	var ops []struct {
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return ""
+	}
+
+	for _, op := range ops {
+		if strings.HasSuffix(op.Path, "/metadata/annotations/"+escapeJSONPointer(annotationKeySidecarVersion)) {
+			var version string
+			if json.Unmarshal(op.Value, &version) == nil {
+				return version
+			}
+		}
+
+		if strings.HasSuffix(op.Path, "/metadata/annotations") {
+			var annotations map[string]string
+			if json.Unmarshal(op.Value, &annotations) == nil && annotations[annotationKeySidecarVersion] != "" {
+				return annotations[annotationKeySidecarVersion]
+			}
+		}
+	}
+
+	return ""
+}
+
+// AsyncAuditSink records the audit records with another sink in the background, so that slow sinks never block the admission requests. Records are dropped if the buffer is full.
+type AsyncAuditSink struct {
+	sink    AuditSink
+	logger  *logrus.Logger
+	records chan *AuditRecord
+	done    chan struct{}
+
+	mu      sync.Mutex
+	dropped uint64
+}
+
+// NewAsyncAuditSink returns a new instance of AsyncAuditSink that buffers up to bufferSize records for sink. Failures of sink are logged with logger.
+func NewAsyncAuditSink(sink AuditSink, bufferSize int, logger *logrus.Logger) *AsyncAuditSink {
+	s := &AsyncAuditSink{
+		sink:    sink,
+		logger:  logger,
+		records: make(chan *AuditRecord, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// Record queues record. It never blocks.
+func (s *AsyncAuditSink) Record(record *AuditRecord) error {
+	select {
+	case s.records <- record:
+		return nil
+	default:
+	}
+
+	s.mu.Lock()
+	s.dropped++
+	s.mu.Unlock()
+	return fmt.Errorf("Audit buffer is full. Dropped audit record of request %s", record.UID)
+}
+
+// Dropped returns the number of records that were dropped because the buffer was full.
+func (s *AsyncAuditSink) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close records the buffered records, stops the background goroutine, and closes the sink if it's an io.Closer. Record must not be called after Close.
+func (s *AsyncAuditSink) Close() error {
+	close(s.records)
+	<-s.done
+
+	if closer, ok := s.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (s *AsyncAuditSink) run() {
+	defer close(s.done)
+	for record := range s.records {
+		if err := s.sink.Record(record); err != nil {
+			s.logger.Errorf("Failed to record audit record of request %s. Reason: %s", record.UID, err)
+		}
+	}
+}
+
+// FileAuditSink appends the audit records to a file as JSON lines. The file is rotated when it exceeds its maximum size.
+type FileAuditSink struct {
+	filename   string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileAuditSink returns a new instance of FileAuditSink that appends to filename. When the file exceeds maxSize bytes, it's renamed to filename.1, and up to maxBackups rotated files are kept. A non-positive maxSize disables rotation.
+func NewFileAuditSink(filename string, maxSize int64, maxBackups int) (*FileAuditSink, error) {
+	s := &FileAuditSink{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Record appends record to the file.
+func (s *FileAuditSink) Record(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the file.
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileAuditSink) open() error {
+	file, err := os.OpenFile(s.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate renames the file to filename.1, shifting the older backups, and reopens the file. The oldest backup is removed.
+func (s *FileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i > 0; i-- {
+			backup := fmt.Sprintf("%s.%d", s.filename, i)
+			if _, err := os.Stat(backup); err == nil {
+				if err := os.Rename(backup, fmt.Sprintf("%s.%d", s.filename, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := os.Rename(s.filename, s.filename+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.filename); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// HTTPAuditSink posts the audit records to an HTTP endpoint as JSON.
+type HTTPAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPAuditSink returns a new instance of HTTPAuditSink that posts to url. Requests that don't complete within timeout fail.
+func NewHTTPAuditSink(url string, timeout time.Duration) *HTTPAuditSink {
+	return &HTTPAuditSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Record posts record to the endpoint. Responses other than 2xx are errors.
+func (s *HTTPAuditSink) Record(record *AuditRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Audit endpoint %s responded with %s", s.url, res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+)
+
+type auditRecorder struct {
+	sync.Mutex
+	records []*AuditRecord
+}
+
+func (r *auditRecorder) Record(record *AuditRecord) error {
+	r.Lock()
+	defer r.Unlock()
+	r.records = append(r.records, record)
+	return nil
+}
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Record(record *AuditRecord) error {
+	return fmt.Errorf("Audit sink is unavailable")
+}
+
+func TestAudit(t *testing.T) {
+	w, err := initWebhookWithConfigMap()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	w.WorkloadMutation = true
+
+	recorder := &auditRecorder{}
+	w.AuditSinks = []AuditSink{failingAuditSink{}, recorder}
+
+	version, err := w.TemplateVersion()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var testCases = []struct {
+		filename                string
+		mutate                  func([]byte) interface{}
+		expectedKind            string
+		expectedOperation       string
+		expectedPreviousVersion string
+	}{
+		{
+			filename:          "http-request-body-valid.json",
+			mutate:            func(data []byte) interface{} { return w.Mutate(context.Background(), data) },
+			expectedKind:      "Pod",
+			expectedOperation: "CREATE",
+		},
+		{
+			filename:                "admission-review-update-deployment-outdated.json",
+			mutate:                  func(data []byte) interface{} { return w.MutateWorkload(context.Background(), data) },
+			expectedKind:            "Deployment",
+			expectedOperation:       "UPDATE",
+			expectedPreviousVersion: "0000000000000000",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.filename, func(t *testing.T) {
+			data, err := test.FixtureHTTPRequestBody(testCase.filename, ".")
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			// a failing sink doesn't fail the admission request
+			recorder.records = nil
+			testCase.mutate(data)
+
+			if len(recorder.records) != 1 {
+				t.Fatalf("Audit records mismatch. Expected: 1. Actual: %d", len(recorder.records))
+			}
+
+			actual := recorder.records[0]
+			if actual.Kind != testCase.expectedKind || actual.Operation != testCase.expectedOperation {
+				t.Errorf("Audited request mismatch. Expected: %s %s. Actual: %s %s", testCase.expectedOperation, testCase.expectedKind, actual.Operation, actual.Kind)
+			}
+
+			if actual.UID == "" || actual.User != "minikube-user" || actual.Namespace != "default" {
+				t.Errorf("Audited request identifiers mismatch. Actual: %+v", actual)
+			}
+
+			if actual.Decision != DecisionPatched {
+				t.Errorf("Decision mismatch. Expected: %s. Actual: %s", DecisionPatched, actual.Decision)
+			}
+
+			if actual.TemplateVersion != version {
+				t.Errorf("Template version mismatch. Expected: %s. Actual: %s", version, actual.TemplateVersion)
+			}
+
+			if actual.PreviousTemplateVersion != testCase.expectedPreviousVersion {
+				t.Errorf("Previous template version mismatch. Expected: %s. Actual: %s", testCase.expectedPreviousVersion, actual.PreviousTemplateVersion)
+			}
+
+			if !json.Valid(actual.Patch) {
+				t.Errorf("Expected patch to be valid JSON. Actual: %s", actual.Patch)
+			}
+		})
+	}
+}
+
+func TestAsyncAuditSink(t *testing.T) {
+	var (
+		taken    = make(chan struct{}, 1)
+		unblock  = make(chan struct{})
+		recorder = &auditRecorder{}
+		sink     = NewAsyncAuditSink(auditSinkFunc(func(record *AuditRecord) error {
+			taken <- struct{}{}
+			<-unblock
+			return recorder.Record(record)
+		}), 1, logrus.New())
+	)
+
+	// the first record is taken by the background goroutine, the second one is buffered and the rest are dropped
+	errs := 0
+	for i := 0; i < 5; i++ {
+		if err := sink.Record(&AuditRecord{UID: "505034df-a300-11e8-b3da-c810c860534d"}); err != nil {
+			errs++
+		}
+
+		if i == 0 {
+			<-taken
+		}
+	}
+
+	if sink.Dropped() != 3 || errs != 3 {
+		t.Errorf("Dropped records mismatch. Expected: 3. Actual: %d (%d errors)", sink.Dropped(), errs)
+	}
+
+	close(unblock)
+	sink.Close()
+
+	if len(recorder.records) != 2 {
+		t.Errorf("Recorded records mismatch. Expected: 2. Actual: %d", len(recorder.records))
+	}
+}
+
+func TestAsyncAuditSinkClose(t *testing.T) {
+	var (
+		recorder = &closingAuditSink{}
+		sink     = NewAsyncAuditSink(recorder, 10, logrus.New())
+	)
+
+	for i := 0; i < 3; i++ {
+		if err := sink.Record(&AuditRecord{UID: "505034df-a300-11e8-b3da-c810c860534d"}); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if len(recorder.records) != 3 {
+		t.Errorf("Recorded records mismatch. Expected: 3. Actual: %d", len(recorder.records))
+	}
+
+	if !recorder.closed {
+		t.Error("Expected the wrapped sink to be closed")
+	}
+}
+
+type closingAuditSink struct {
+	auditRecorder
+	closed bool
+}
+
+func (s *closingAuditSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestAuditRecordRedactsPatch(t *testing.T) {
+	patch := []byte(`[{"op":"add","path":"/spec/containers/1","value":{"name":"nginx","env":[{"name":"DB_PASSWORD","value":"s3cr3t"}]}},{"op":"add","path":"/metadata/annotations","value":{"sidecar.example.org/template-version":"d89fb08ff4fbd920"}}]`)
+	review := &admissionv1beta1.AdmissionReview{
+		Request:  &admissionv1beta1.AdmissionRequest{UID: "505034df-a300-11e8-b3da-c810c860534d"},
+		Response: &admissionv1beta1.AdmissionResponse{Allowed: true, Patch: patch},
+	}
+
+	actual := newAuditRecord(review)
+	if strings.Contains(string(actual.Patch), "s3cr3t") || !strings.Contains(string(actual.Patch), redacted) {
+		t.Errorf("Expected the env var value to be redacted. Actual: %s", actual.Patch)
+	}
+
+	if actual.TemplateVersion != "d89fb08ff4fbd920" {
+		t.Errorf("Template version mismatch. Expected: d89fb08ff4fbd920. Actual: %s", actual.TemplateVersion)
+	}
+}
+
+type auditSinkFunc func(record *AuditRecord) error
+
+func (f auditSinkFunc) Record(record *AuditRecord) error {
+	return f(record)
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	record := &AuditRecord{UID: "505034df-a300-11e8-b3da-c810c860534d", Decision: DecisionPatched}
+	line, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// each file holds two records
+	filename := filepath.Join(dir, "audit.log")
+	sink, err := NewFileAuditSink(filename, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 7; i++ {
+		if err := sink.Record(record); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+
+	expected := map[string]int{
+		"audit.log":   1,
+		"audit.log.1": 2,
+		"audit.log.2": 2,
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if len(files) != len(expected) {
+		t.Errorf("Files mismatch. Expected: %d. Actual: %d", len(expected), len(files))
+	}
+
+	for name, lines := range expected {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if actual := strings.Count(string(b), "\n"); actual != lines {
+			t.Errorf("Lines mismatch of %s. Expected: %d. Actual: %d", name, lines, actual)
+		}
+	}
+}
+
+func TestHTTPAuditSink(t *testing.T) {
+	var received []*AuditRecord
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/unavailable" {
+			http.Error(res, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		var record AuditRecord
+		if err := json.NewDecoder(req.Body).Decode(&record); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, &record)
+	}))
+	defer server.Close()
+
+	record := &AuditRecord{UID: "505034df-a300-11e8-b3da-c810c860534d", Decision: DecisionPatched}
+	if err := NewHTTPAuditSink(server.URL+"/audit", time.Second).Record(record); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if len(received) != 1 || received[0].UID != record.UID {
+		t.Errorf("Received records mismatch. Actual: %+v", received)
+	}
+
+	if err := NewHTTPAuditSink(server.URL+"/unavailable", time.Second).Record(record); err == nil {
+		t.Error("Expected error to occur")
+	}
+}
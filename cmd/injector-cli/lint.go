@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	webhook "github.com/ihcsim/sidecar-injector"
+)
+
+func lint(args []string, in io.Reader, out io.Writer) error {
+	var (
+		flags         = flag.NewFlagSet("lint", flag.ExitOnError)
+		output        = flags.String("output", "text", "Output format. One of 'text' or 'json'")
+		failOnWarning = flags.Bool("fail-on-warning", false, "Exit with a non-zero code if there are any warnings")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Usage: injector-cli lint [flags] <template>. Use - to read the template from stdin")
+	}
+
+	filename := flags.Arg(0)
+	var (
+		data []byte
+		err  error
+	)
+	if filename == "-" {
+		data, err = ioutil.ReadAll(in)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	findings := webhook.LintTemplate(data)
+	if err := printFindings(filename, findings, *output, out); err != nil {
+		return err
+	}
+
+	var errs, warnings int
+	for _, finding := range findings {
+		if finding.Severity == webhook.SeverityError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+
+	if errs > 0 || (*failOnWarning && warnings > 0) {
+		return fmt.Errorf("%s: %d error(s) and %d warning(s) found", filename, errs, warnings)
+	}
+
+	return nil
+}
+
+func printFindings(filename string, findings []*webhook.LintFinding, output string, out io.Writer) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			File     string                 `json:"file"`
+			Findings []*webhook.LintFinding `json:"findings"`
+		}{filename, findings})
+
+	case "text":
+		for _, finding := range findings {
+			location := filename
+			if finding.Line > 0 {
+				location = fmt.Sprintf("%s:%d", filename, finding.Line)
+			}
+
+			message := finding.Message
+			if finding.Field != "" {
+				message = finding.Field + ": " + message
+			}
+
+			fmt.Fprintf(out, "%s: %s: %s (%s)\n", location, finding.Severity, message, finding.Rule)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported output format %q", output)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	webhook "github.com/ihcsim/sidecar-injector"
+)
+
+func TestLint(t *testing.T) {
+	template := `
+name: proxy
+image: envoyproxy/envoy:v1.8.0
+securityContext:
+  allowPrivilegeEscalation: true
+resources:
+  requests:
+    cpu: 100m
+    memory: 64Mi
+  limits:
+    cpu: 200m
+    memory: 128Mi
+`
+
+	t.Run("Text", func(t *testing.T) {
+		expected := "sidecar.yaml:5: warning: securityContext.allowPrivilegeEscalation: privilege escalation is allowed (privileged)\n"
+
+		var out bytes.Buffer
+		if err := printFindings("sidecar.yaml", webhook.LintTemplate([]byte(template)), "text", &out); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if out.String() != expected {
+			t.Errorf("Output mismatch\nExpected: %s\nActual: %s", expected, out.String())
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := lint([]string{"-output", "json", "-"}, strings.NewReader("name: proxy\nimage: envoy:v1\nport: 80\n"), &out); err == nil {
+			t.Fatal("Expected schema errors to fail the command")
+		}
+
+		var actual struct {
+			File     string
+			Findings []*webhook.LintFinding
+		}
+		if err := json.Unmarshal(out.Bytes(), &actual); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(actual.Findings) != 1 || actual.Findings[0].Rule != webhook.RuleSchema || actual.Findings[0].Line != 3 {
+			t.Errorf("Findings mismatch. Actual: %+v", actual.Findings)
+		}
+	})
+
+	t.Run("Warnings", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := lint([]string{"-"}, strings.NewReader(template), &out); err != nil {
+			t.Error("Expected warnings not to fail the command. Actual: ", err)
+		}
+
+		if err := lint([]string{"-fail-on-warning", "-"}, strings.NewReader(template), &out); err == nil {
+			t.Error("Expected warnings to fail the command with -fail-on-warning")
+		}
+	})
+}
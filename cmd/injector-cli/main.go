@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	webhook "github.com/ihcsim/sidecar-injector"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const usage = `Usage: injector-cli [flags] <command> [command flags]
+
+Commands:
+  report    Lists the injected pods grouped by sidecar template version
+  lint      Checks a sidecar template file for schema errors and risky settings
+
+Flags:
+`
+
+var (
+	server    = ""
+	tokenFile = ""
+	caFile    = ""
+	insecure  = false
+)
+
+func init() {
+	flag.StringVar(&server, "server", "", "Address of the Kubernetes API server. Leave empty to use the in-cluster config")
+	flag.StringVar(&tokenFile, "token-file", "", "Location of the bearer token file used to authenticate with the API server")
+	flag.StringVar(&caFile, "ca-file", "", "Location of the CA cert file of the API server")
+	flag.BoolVar(&insecure, "insecure-skip-tls-verify", false, "Skip the verification of the API server's TLS cert")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	webhook.NewClient = newClient
+
+	var err error
+	switch command, args := flag.Arg(0), flag.Args()[1:]; command {
+	case "report":
+		err = report(args, os.Stdout)
+	case "lint":
+		err = lint(args, os.Stdin, os.Stdout)
+	default:
+		err = fmt.Errorf("Unknown command %q", command)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func newClient() (kubernetes.Interface, error) {
+	if server == "" {
+		return webhook.NewClientset()
+	}
+
+	config := &rest.Config{
+		Host: server,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile:   caFile,
+			Insecure: insecure,
+		},
+	}
+
+	if tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		config.BearerToken = string(token)
+	}
+
+	return kubernetes.NewForConfig(config)
+}
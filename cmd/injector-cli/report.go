@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	webhook "github.com/ihcsim/sidecar-injector"
+)
+
+func report(args []string, out io.Writer) error {
+	var (
+		flags          = flag.NewFlagSet("report", flag.ExitOnError)
+		namespace      = flags.String("namespace", "", "Namespace of the pods. Leave empty to list pods in all namespaces")
+		output         = flags.String("output", "text", "Output format. One of 'text' or 'json'")
+		failOnOutdated = flags.Bool("fail-on-outdated", false, "Exit with a non-zero code if any pods have an outdated sidecar")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := webhook.New()
+	if err != nil {
+		return err
+	}
+
+	r, err := w.Report(*namespace)
+	if err != nil {
+		return err
+	}
+
+	if err := printReport(r, *output, out); err != nil {
+		return err
+	}
+
+	if outdated := r.OutdatedPods(); *failOnOutdated && outdated > 0 {
+		return fmt.Errorf("%d pod(s) have an outdated sidecar", outdated)
+	}
+
+	return nil
+}
+
+func printReport(r *webhook.RolloutReport, output string, out io.Writer) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+
+	case "text":
+		fmt.Fprintf(out, "Current template version: %s\n\n", r.CurrentVersion)
+
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tSTATUS\tNAMESPACE\tPOD")
+		for _, version := range r.Versions {
+			status := "up-to-date"
+			if version.Outdated {
+				status = "outdated"
+			}
+
+			for _, pod := range version.Pods {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", version.Version, status, pod.Namespace, pod.Name)
+			}
+		}
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("Unsupported output format %q", output)
+}
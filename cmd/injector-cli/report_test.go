@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	webhook "github.com/ihcsim/sidecar-injector"
+)
+
+func TestPrintReport(t *testing.T) {
+	r := &webhook.RolloutReport{
+		CurrentVersion: "v2",
+		Versions: []webhook.VersionReport{
+			{Version: "v1", Outdated: true, Pods: []webhook.PodRef{{Namespace: "default", Name: "web-1"}}},
+			{Version: "v2", Outdated: false, Pods: []webhook.PodRef{{Namespace: "default", Name: "web-0"}}},
+		},
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		expected := `Current template version: v2
+
+VERSION  STATUS      NAMESPACE  POD
+v1       outdated    default    web-1
+v2       up-to-date  default    web-0
+`
+
+		var out bytes.Buffer
+		if err := printReport(r, "text", &out); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if out.String() != expected {
+			t.Errorf("Output mismatch\nExpected: %s\nActual: %s", expected, out.String())
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := printReport(r, "yaml", &out); err == nil {
+			t.Error("Expected error didn't occur")
+		}
+	})
+}
@@ -2,11 +2,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	webhook "github.com/ihcsim/sidecar-injector"
 	"github.com/sirupsen/logrus"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 var (
@@ -15,6 +24,67 @@ var (
 	keyFile  = ""
 	debug    = ""
 
+	logFormat = "text"
+
+	overrideRegistries = ""
+	allowedRegistries  = ""
+	requireDigest      = false
+	digestMappingFile  = ""
+	enforcedNamespaces = ""
+	mutateWorkloads    = false
+	limitRanges        = false
+	resourceQuotas     = false
+
+	rolloutController = false
+	rolloutInterval   = time.Minute
+	rolloutNamespaces = ""
+	rolloutQPS        = 0.1
+	rolloutBurst      = 1
+	rolloutDryRun     = false
+
+	leaderElect          = false
+	leaderElectNamespace = "default"
+	leaderElectLockName  = "sidecar-injector-leader"
+
+	reconcileWebhookConfig  = false
+	reconcileInterval       = 5 * time.Minute
+	webhookConfigName       = "sidecar-injector-configuration"
+	webhookServiceNamespace = "default"
+	webhookServiceName      = "sidecar-injector"
+	caFile                  = ""
+	failurePolicy           = ""
+	webhookTimeout          = 10 * time.Second
+	namespaceSelector       = ""
+	objectSelector          = ""
+
+	auditLogFile        = ""
+	auditLogMaxSize     = 100
+	auditLogMaxBackups  = 5
+	auditWebhookURL     = ""
+	auditWebhookTimeout = 5 * time.Second
+	auditBufferSize     = 1000
+
+	otlpEndpoint     = ""
+	traceServiceName = "sidecar-injector"
+
+	admissionTimeout   = 10 * time.Second
+	timeoutMargin      = time.Second
+	failOpen           = true
+	validationFailOpen = false
+	shutdownTimeout    = 20 * time.Second
+
+	templateSources           = "configmap"
+	templateFile              = "/etc/sidecar-template"
+	templateURL               = ""
+	templateRefresh           = 30 * time.Second
+	templateConfigMap         = "default/sidecar-spec"
+	templateConfigMapSelector = ""
+	templateCRD               = "default/sidecar-spec"
+
+	excludedNamespaces = ""
+	excludedLabels     = ""
+	excludeStaticPods  = true
+
 	log = logrus.New()
 )
 
@@ -23,8 +93,71 @@ func init() {
 	flag.StringVar(&certFile, "cert-file", "/etc/secret/tls.crt", "Location of the TLS cert file")
 	flag.StringVar(&keyFile, "key-file", "/etc/secret/tls.key", "Location of the TLS private key file")
 	flag.StringVar(&debug, "debug", "false", "Set to 'true' to enable more verbose debug mode")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of the logs. Either 'json' or 'text'")
+	flag.StringVar(&allowedRegistries, "allowed-registries", "", "Comma-separated list of registries that sidecar images can be pulled from. Leave empty to allow all registries")
+	flag.BoolVar(&requireDigest, "require-digest", false, "Reject sidecar images that aren't pinned to a digest")
+	flag.StringVar(&digestMappingFile, "digest-mapping-file", "", "Location of the JSON file that maps sidecar image tags to digest-pinned images")
+	flag.StringVar(&enforcedNamespaces, "enforced-namespaces", "", "Comma-separated list of namespaces where the validating webhook rejects pods without the sidecar container")
+	flag.BoolVar(&mutateWorkloads, "mutate-workloads", false, "Inject the sidecar container into the pod templates of deployments, stateful sets, daemon sets, jobs and cron jobs")
+	flag.BoolVar(&limitRanges, "limit-ranges", false, "Fill in the sidecar resources with the container defaults of the LimitRanges of the pod's namespace, and clamp them into their range")
+	flag.BoolVar(&resourceQuotas, "resource-quotas", false, "Reject the pods that would exceed a ResourceQuota of their namespace because of the sidecar, with a message that explains the violation")
+	flag.BoolVar(&rolloutController, "rollout-controller", false, "Run the controller that restarts workloads whose pods have an outdated sidecar")
+	flag.DurationVar(&rolloutInterval, "rollout-interval", time.Minute, "Interval at which the rollout controller checks for outdated sidecars")
+	flag.StringVar(&rolloutNamespaces, "rollout-namespaces", "", "Comma-separated list of namespaces where the rollout controller can restart workloads. Leave empty to allow all namespaces")
+	flag.Float64Var(&rolloutQPS, "rollout-qps", 0.1, "Maximum number of workload restarts per second")
+	flag.IntVar(&rolloutBurst, "rollout-burst", 1, "Maximum burst of workload restarts")
+	flag.BoolVar(&rolloutDryRun, "rollout-dry-run", false, "Log the workloads that the rollout controller would restart, without restarting them")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Elect a leader among the replicas to run the background loops. Admission requests are served by all replicas")
+	flag.StringVar(&leaderElectNamespace, "leader-elect-namespace", "default", "Namespace of the leader election lock")
+	flag.StringVar(&leaderElectLockName, "leader-elect-lock-name", "sidecar-injector-leader", "Name of the leader election lock configmap")
+	flag.BoolVar(&reconcileWebhookConfig, "reconcile-webhook-config", false, "Create and patch the MutatingWebhookConfiguration of the server on start and at every reconcile interval")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", 5*time.Minute, "Interval at which the MutatingWebhookConfiguration is reconciled")
+	flag.StringVar(&webhookConfigName, "webhook-config-name", "sidecar-injector-configuration", "Name of the MutatingWebhookConfiguration")
+	flag.StringVar(&webhookServiceNamespace, "webhook-service-namespace", "default", "Namespace of the service of this webhook admission server")
+	flag.StringVar(&webhookServiceName, "webhook-service-name", "sidecar-injector", "Name of the service of this webhook admission server")
+	flag.StringVar(&caFile, "ca-file", "/etc/secret/ca.crt", "Location of the CA cert that signed the TLS cert. It's used as the CA bundle of the MutatingWebhookConfiguration")
+	flag.StringVar(&failurePolicy, "failure-policy", "Ignore", "Failure policy of the MutatingWebhookConfiguration. Either 'Ignore' or 'Fail'")
+	flag.DurationVar(&webhookTimeout, "webhook-timeout", 10*time.Second, "Duration that the API server waits for this webhook admission server to respond. It must be between 1s and 30s")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "", "Label selector of the namespaces whose requests are sent to this webhook admission server. Leave empty to select all namespaces")
+	flag.StringVar(&objectSelector, "object-selector", "", "Label selector of the objects whose requests are sent to this webhook admission server. Leave empty to select all objects")
+	flag.StringVar(&auditLogFile, "audit-log-file", "", "Location of the file where the mutation decisions are recorded as JSON lines. Leave empty to disable the file audit log")
+	flag.IntVar(&auditLogMaxSize, "audit-log-max-size", 100, "Maximum size in megabytes of the audit log file before it's rotated")
+	flag.IntVar(&auditLogMaxBackups, "audit-log-max-backups", 5, "Maximum number of rotated audit log files to keep")
+	flag.StringVar(&auditWebhookURL, "audit-webhook-url", "", "URL of the HTTP endpoint where the mutation decisions are posted as JSON. Leave empty to disable the audit webhook")
+	flag.DurationVar(&auditWebhookTimeout, "audit-webhook-timeout", 5*time.Second, "Timeout of the requests to the audit webhook")
+	flag.IntVar(&auditBufferSize, "audit-buffer-size", 1000, "Maximum number of audit records buffered per sink. Records are dropped when the buffer is full")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP endpoint where the traces of the admission requests are exported e.g. http://otel-collector:4318/v1/traces. Leave empty to disable tracing")
+	flag.StringVar(&traceServiceName, "trace-service-name", "sidecar-injector", "Service name of the exported traces")
+	flag.DurationVar(&admissionTimeout, "admission-timeout", 10*time.Second, "Deadline of the admission requests that don't have the API server's timeout query parameter")
+	flag.DurationVar(&timeoutMargin, "timeout-margin", time.Second, "Duration reserved before the deadline of an admission request to respond to the API server. It's capped at half of the deadline")
+	flag.BoolVar(&failOpen, "fail-open", true, "Admit mutation requests unchanged if they can't be handled before their deadline. Set to 'false' to reject them instead")
+	flag.BoolVar(&validationFailOpen, "validation-fail-open", false, "Admit validation requests if they can't be handled before their deadline. By default, they are rejected")
+	flag.StringVar(&templateSources, "template-sources", "configmap", "Comma-separated list of the sources of the sidecar template, in their precedence order. Supported sources are 'file', 'url', 'crd' and 'configmap'")
+	flag.StringVar(&templateFile, "template-file", "/etc/sidecar-template", "Location of the sidecar template file, or of the directory with the sidecar.json template file, of the file source")
+	flag.StringVar(&templateURL, "template-url", "", "HTTP(S) URL of the sidecar template of the url source")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 20*time.Second, "Duration that the server waits for the in-flight requests to complete on SIGTERM, before it flushes the buffered audit records and spans and exits")
+	flag.DurationVar(&templateRefresh, "template-refresh", 30*time.Second, "Interval at which the sidecar template of the url source is revalidated")
+	flag.StringVar(&templateConfigMap, "template-configmap", "default/sidecar-spec", "Namespace and name of the sidecar template configmap of the configmap source")
+	flag.StringVar(&templateConfigMapSelector, "template-configmap-selector", "", "Label selector of the sidecar template configmaps of the configmap source, in the namespace of -template-configmap. The first matching configmap by name is used")
+	flag.StringVar(&templateCRD, "template-crd", "default/sidecar-spec", "Namespace and name of the SidecarTemplate custom resource of the crd source")
+	flag.StringVar(&excludedNamespaces, "excluded-namespaces", "kube-system,kube-public", "Comma-separated list of namespaces whose pods are never mutated or validated")
+	flag.StringVar(&excludedLabels, "excluded-labels", "app=sidecar-injector", "Comma-separated list of key=value labels. Pods with any of these labels are never mutated or validated")
+	flag.BoolVar(&excludeStaticPods, "exclude-static-pods", true, "Never mutate or validate static pods and their mirror pods")
+	flag.StringVar(&overrideRegistries, "override-registries", "", "Comma-separated list of registries that the sidecar image annotation can pull from. Leave empty to allow all registries")
+}
+
+func main() {
 	flag.Parse()
 
+	switch logFormat {
+	case "json":
+		log.Formatter = &logrus.JSONFormatter{}
+	case "text":
+		log.Formatter = &logrus.TextFormatter{}
+	default:
+		log.Fatalf("Unsupported log format %q", logFormat)
+	}
+
 	if strings.ToLower(debug) == "true" {
 		log.SetLevel(logrus.DebugLevel)
 		log.Debug("Starting in debug mode...")
@@ -32,9 +165,7 @@ func init() {
 		log.SetLevel(logrus.InfoLevel)
 	}
 	log.SetOutput(os.Stdout)
-}
 
-func main() {
 	log.Infof("Listening at port %s... ", port)
 	log.Infof("Using TLS cert at %s and key at %s...", certFile, keyFile)
 
@@ -42,9 +173,239 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	s.Handler = http.HandlerFunc(s.serve)
+	s.OverrideRegistries = splitList(overrideRegistries)
+	if s.ImagePolicy, err = imagePolicy(); err != nil {
+		log.Fatal(err)
+	}
+	s.EnforcedNamespaces = splitList(enforcedNamespaces)
+	s.WorkloadMutation = mutateWorkloads
+	s.LimitRanges = limitRanges
+	s.ResourceQuotas = resourceQuotas
+	if s.TemplateSource, err = templateSource(s.Webhook); err != nil {
+		log.Fatal(err)
+	}
+	s.FailOpen = failOpen
+	s.ValidationFailOpen = validationFailOpen
+	if s.Exclusions, err = exclusions(); err != nil {
+		log.Fatal(err)
+	}
+	if s.AuditSinks, err = auditSinks(); err != nil {
+		log.Fatal(err)
+	}
+	if otlpEndpoint != "" {
+		s.Tracer = webhook.NewTracer(webhook.NewOTLPExporter(otlpEndpoint, traceServiceName, 100, 5*time.Second, 10*time.Second, log))
+	}
+	s.Handler = s.routes()
+
+	if reconcileWebhookConfig {
+		if s.reconciler, err = webhookConfigReconciler(s.Webhook); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if leaderElect {
+		identity, err := leaderIdentity()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s.elector = webhook.NewLeaderElector(s.Webhook, leaderElectNamespace, leaderElectLockName, identity)
+		go s.elector.Run(wait.NeverStop, s.runBackgroundLoops)
+	} else {
+		go s.runBackgroundLoops(wait.NeverStop)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		sig := <-signals
 
-	if err := s.ListenAndServeTLS("", ""); err != nil {
+		log.Infof("Received %s. Shutting down...", sig)
+		s.shutdown(shutdownTimeout)
+		close(stopped)
+	}()
+
+	if err := s.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-stopped
+}
+
+// runBackgroundLoops runs the enabled background loops until stopCh is closed. With leader election, they only run on the leader.
+func (w *WebhookServer) runBackgroundLoops(stopCh <-chan struct{}) {
+	if rolloutController {
+		controller := webhook.NewRolloutController(w.Webhook, float32(rolloutQPS), rolloutBurst)
+		controller.Namespaces = splitList(rolloutNamespaces)
+		controller.DryRun = rolloutDryRun
+		go controller.Run(rolloutInterval, stopCh)
+	}
+
+	if w.reconciler != nil {
+		go w.reconciler.Run(reconcileInterval, stopCh)
+	}
+}
+
+func webhookConfigReconciler(w *webhook.Webhook) (*webhook.WebhookConfigReconciler, error) {
+	// the API server rejects webhook timeouts outside of 1 to 30 seconds, so the reconciler would fail forever
+	if webhookTimeout < time.Second || webhookTimeout > 30*time.Second {
+		return nil, fmt.Errorf("Invalid webhook timeout %s. It must be between 1s and 30s", webhookTimeout)
+	}
+
+	caBundle, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	reconciler := webhook.NewWebhookConfigReconciler(w, webhookConfigName, webhookServiceNamespace, webhookServiceName, caBundle)
+	reconciler.PodsPath = pathMutatePods
+	reconciler.WorkloadsPath = pathMutateWorkloads
+	reconciler.Timeout = webhookTimeout
+
+	switch policy := admissionregistrationv1beta1.FailurePolicyType(failurePolicy); policy {
+	case admissionregistrationv1beta1.Ignore, admissionregistrationv1beta1.Fail:
+		reconciler.FailurePolicy = policy
+	default:
+		return nil, fmt.Errorf("Unsupported failure policy %q", failurePolicy)
+	}
+
+	if namespaceSelector != "" {
+		if reconciler.NamespaceSelector, err = metav1.ParseToLabelSelector(namespaceSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	if objectSelector != "" {
+		if reconciler.ObjectSelector, err = metav1.ParseToLabelSelector(objectSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	return reconciler, nil
+}
+
+// leaderIdentity returns the identity of this replica in the leader election. The POD_NAME environment variable is used if it's set. Otherwise, the hostname is used.
+func leaderIdentity() (string, error) {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name, nil
+	}
+
+	return os.Hostname()
+}
+
+func imagePolicy() (*webhook.ImagePolicy, error) {
+	if allowedRegistries == "" && !requireDigest && digestMappingFile == "" {
+		return nil, nil
+	}
+
+	policy := &webhook.ImagePolicy{
+		AllowedRegistries: splitList(allowedRegistries),
+		RequireDigest:     requireDigest,
+	}
+
+	if digestMappingFile != "" {
+		digests, err := webhook.LoadDigestMapping(digestMappingFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.Digests = digests
+	}
+
+	return policy, nil
+}
+
+// auditSinks returns the configured audit sinks. Each sink records in the background, so that it never blocks the admission requests.
+func auditSinks() ([]webhook.AuditSink, error) {
+	var sinks []webhook.AuditSink
+	if auditLogFile != "" {
+		sink, err := webhook.NewFileAuditSink(auditLogFile, int64(auditLogMaxSize)*1024*1024, auditLogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, webhook.NewAsyncAuditSink(sink, auditBufferSize, log))
+	}
+
+	if auditWebhookURL != "" {
+		sink := webhook.NewHTTPAuditSink(auditWebhookURL, auditWebhookTimeout)
+		sinks = append(sinks, webhook.NewAsyncAuditSink(sink, auditBufferSize, log))
+	}
+
+	return sinks, nil
+}
+
+// templateSource returns the sidecar template sources of the -template-sources flag, in their precedence order.
+func templateSource(w *webhook.Webhook) (webhook.TemplateSource, error) {
+	var sources webhook.TemplateSources
+	for _, name := range splitList(templateSources) {
+		switch name {
+		case "file":
+			sources = append(sources, webhook.NewFileSource(templateFile))
+
+		case "url":
+			if templateURL == "" {
+				return nil, fmt.Errorf("The url template source requires -template-url")
+			}
+			sources = append(sources, webhook.NewHTTPSource(templateURL, templateRefresh, 5*time.Second, log))
+
+		case "crd":
+			namespace, name, err := namespacedName(templateCRD)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, &webhook.CRDSource{Client: w.Client.CoreV1().RESTClient(), Namespace: namespace, Name: name})
+
+		case "configmap":
+			namespace, name, err := namespacedName(templateConfigMap)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, &webhook.ConfigMapSource{Client: w.Client, Namespace: namespace, Name: name, Selector: templateConfigMapSelector})
+
+		default:
+			return nil, fmt.Errorf("Unsupported template source %q", name)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("At least one template source is required")
+	}
+
+	return sources, nil
+}
+
+func namespacedName(s string) (string, string, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Invalid name %q. Expected namespace/name", s)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func exclusions() (*webhook.Exclusions, error) {
+	labels := map[string]string{}
+	for _, label := range splitList(excludedLabels) {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid excluded label %q. Expected key=value", label)
+		}
+		labels[kv[0]] = kv[1]
+	}
+
+	return &webhook.Exclusions{
+		Namespaces: splitList(excludedNamespaces),
+		Labels:     labels,
+		StaticPods: excludeStaticPods,
+	}, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metrics records the number and duration of the requests handled by each of the server's handlers. It's exposed in the Prometheus text format.
+type metrics struct {
+	sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*duration
+}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+type duration struct {
+	sum   float64
+	count uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  map[requestKey]uint64{},
+		durations: map[string]*duration{},
+	}
+}
+
+func (m *metrics) observe(handler string, code int, elapsed time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.requests[requestKey{handler: handler, code: code}]++
+
+	d, exists := m.durations[handler]
+	if !exists {
+		d = &duration{}
+		m.durations[handler] = d
+	}
+	d.sum += elapsed.Seconds()
+	d.count++
+}
+
+func (m *metrics) write(out io.Writer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	handlers := make([]string, 0, len(m.durations))
+	for handler := range m.durations {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+
+	if _, err := fmt.Fprintln(out, "# HELP sidecar_injector_requests_total Total number of HTTP requests handled by the server."); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "# TYPE sidecar_injector_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(out, "sidecar_injector_requests_total{handler=%q,code=%q} %d\n", key.handler, strconv.Itoa(key.code), m.requests[key])
+	}
+
+	fmt.Fprintln(out, "# HELP sidecar_injector_request_duration_seconds Duration of the HTTP requests handled by the server.")
+	fmt.Fprintln(out, "# TYPE sidecar_injector_request_duration_seconds summary")
+	for _, handler := range handlers {
+		d := m.durations[handler]
+		fmt.Fprintf(out, "sidecar_injector_request_duration_seconds_sum{handler=%q} %g\n", handler, d.sum)
+		fmt.Fprintf(out, "sidecar_injector_request_duration_seconds_count{handler=%q} %d\n", handler, d.count)
+	}
+
+	return nil
+}
+
+func writeGauge(out io.Writer, name, help string, value float64) {
+	fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(out, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(out, "%s %g\n", name, value)
+}
+
+// statusRecorder is a http.ResponseWriter that remembers the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	webhook "github.com/ihcsim/sidecar-injector"
 	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+)
+
+const (
+	pathLegacy          = "/"
+	pathMutatePods      = "/mutate/pods"
+	pathMutateWorkloads = "/mutate/workloads"
+	pathValidatePods    = "/validate/pods"
+	pathHealthz         = "/healthz"
+	pathReadyz          = "/readyz"
+	pathMetrics         = "/metrics"
 )
 
 // WebhookServer is the webhook's TLS server. Its embedded http.Server handles all incoming requests. The webhook performs the mutation and interacts with the k8s API Server.
@@ -15,6 +28,14 @@ type WebhookServer struct {
 	*http.Server
 	*webhook.Webhook
 	*logrus.Entry
+
+	metrics *metrics
+
+	// elector is the leader elector of the background loops. It's nil if leader election isn't enabled.
+	elector *webhook.LeaderElector
+
+	// reconciler reconciles the MutatingWebhookConfiguration of the server. It's nil if the reconciliation isn't enabled.
+	reconciler *webhook.WebhookConfigReconciler
 }
 
 // NewWebhookServer returns a new instance of the WebhookServer.
@@ -34,13 +55,142 @@ func NewWebhookServer(port, certFile, keyFile string) (*WebhookServer, error) {
 		return nil, err
 	}
 	webhook.SetLogLevel(log.Level)
+	webhook.SetLogFormatter(log.Formatter)
 	requestLogger := logrus.NewEntry(log)
 
-	return &WebhookServer{server, webhook, requestLogger}, nil
+	return &WebhookServer{
+		Server:  server,
+		Webhook: webhook,
+		Entry:   requestLogger,
+		metrics: newMetrics(),
+	}, nil
+}
+
+// shutdown stops the server from accepting new requests, and waits up to timeout for the in-flight requests to complete. Then it closes the webhook to flush its buffered audit records and spans.
+func (w *WebhookServer) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := w.Shutdown(ctx); err != nil {
+		w.Errorf("Failed to shut down the server gracefully. Reason: %s", err)
+	}
+
+	if err := w.Webhook.Close(); err != nil {
+		w.Errorf("%s", err)
+	}
+}
+
+// routes returns the handler that routes the incoming requests to the server's handlers based on their paths.
+func (w *WebhookServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathMutatePods, w.instrument(pathMutatePods, w.mutatePods))
+	mux.HandleFunc(pathMutateWorkloads, w.instrument(pathMutateWorkloads, w.mutateWorkloads))
+	mux.HandleFunc(pathValidatePods, w.instrument(pathValidatePods, w.validatePods))
+	mux.HandleFunc(pathHealthz, w.healthz)
+	mux.HandleFunc(pathReadyz, w.readyz)
+	mux.HandleFunc(pathMetrics, w.serveMetrics)
+
+	// the webhook configurations of earlier releases send pod requests to the root path
+	mux.HandleFunc(pathLegacy, w.instrument(pathLegacy, w.legacy))
+	return mux
+}
+
+// legacy handles the requests to the root path like the mutatePods handler. Unknown paths aren't admitted.
+func (w *WebhookServer) legacy(res http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != pathLegacy {
+		http.NotFound(res, req)
+		return
+	}
+
+	w.admit(res, req, pathLegacy, w.Mutate)
+}
+
+func (w *WebhookServer) mutatePods(res http.ResponseWriter, req *http.Request) {
+	w.admit(res, req, pathMutatePods, w.Mutate)
+}
+
+func (w *WebhookServer) mutateWorkloads(res http.ResponseWriter, req *http.Request) {
+	w.admit(res, req, pathMutateWorkloads, w.MutateWorkload)
+}
+
+func (w *WebhookServer) validatePods(res http.ResponseWriter, req *http.Request) {
+	w.admit(res, req, pathValidatePods, w.Validate)
+}
+
+func (w *WebhookServer) healthz(res http.ResponseWriter, req *http.Request) {
+	if _, err := res.Write([]byte("ok")); err != nil {
+		w.handleRequestError(res, err, http.StatusInternalServerError)
+	}
+}
+
+// readyz reports the readiness of the server, with the leader election state of its background loops. All replicas are ready to serve admission requests, regardless of their leadership.
+func (w *WebhookServer) readyz(res http.ResponseWriter, req *http.Request) {
+	status := readiness{Ready: true}
+	if w.elector != nil {
+		status.LeaderElection = &leaderElectionStatus{
+			Identity: w.elector.Identity,
+			Leader:   w.elector.Leader(),
+			IsLeader: w.elector.IsLeader(),
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(status); err != nil {
+		w.handleRequestError(res, err, http.StatusInternalServerError)
+	}
+}
+
+type readiness struct {
+	Ready          bool                  `json:"ready"`
+	LeaderElection *leaderElectionStatus `json:"leaderElection,omitempty"`
+}
+
+type leaderElectionStatus struct {
+	Identity string `json:"identity"`
+	Leader   string `json:"leader"`
+	IsLeader bool   `json:"isLeader"`
+}
+
+func (w *WebhookServer) serveMetrics(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := w.metrics.write(res); err != nil {
+		w.handleRequestError(res, err, http.StatusInternalServerError)
+		return
+	}
+
+	if w.elector != nil {
+		leader := 0.0
+		if w.elector.IsLeader() {
+			leader = 1
+		}
+		writeGauge(res, "sidecar_injector_leader", "Set to 1 if this replica is the leader of the background loops.", leader)
+	}
 }
 
-func (w *WebhookServer) serve(res http.ResponseWriter, req *http.Request) {
-	w.Data = logrus.Fields{"Remote Addr": req.RemoteAddr}
+// instrument wraps handler to record its request count and duration in the server's metrics.
+func (w *WebhookServer) instrument(path string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: res, code: http.StatusOK}
+		handler(recorder, req)
+		w.metrics.observe(path, recorder.code, time.Since(start))
+	}
+}
+
+// admit serves an admission request with review. The request must be handled within its latency budget, otherwise it's admitted or rejected based on the webhook's fail-open policy of the mutation or the validation requests. Every request is logged with its own logger, which carries the admission identifiers, decision and duration of the request. The request and response bodies are logged at debug level, with their Secret-like fields redacted. The request is traced as a child of the trace context in its headers.
+func (w *WebhookServer) admit(res http.ResponseWriter, req *http.Request, path string, review func(context.Context, []byte) *admissionv1beta1.AdmissionReview) {
+	start := time.Now()
+	logger := w.WithFields(logrus.Fields{"remoteAddr": req.RemoteAddr, "handler": path})
+
+	ctx, cancel := context.WithTimeout(req.Context(), budget(req))
+	defer cancel()
+
+	ctx, span := w.Tracer.StartServer(ctx, "serve", req.Header)
+	defer span.Finish()
+	span.SetAttribute("http.route", path)
+	if span != nil {
+		logger = logger.WithField("traceId", span.Context.TraceID.String())
+	}
 
 	var (
 		data []byte
@@ -49,32 +199,113 @@ func (w *WebhookServer) serve(res http.ResponseWriter, req *http.Request) {
 	if req.Body != nil {
 		data, err = ioutil.ReadAll(req.Body)
 		if err != nil {
-			w.handleRequestError(res, err, http.StatusBadRequest)
+			requestError(logger, res, err, http.StatusBadRequest)
 			return
 		}
-		w.Debugf("HTTP Request body: %s", data)
+		logger.Debugf("HTTP Request body: %s", webhook.RedactJSON(data))
 	}
 
 	if len(data) == 0 {
 		return
 	}
 
-	response := w.Mutate(data)
+	reviewed := make(chan *admissionv1beta1.AdmissionReview, 1)
+	go func() {
+		reviewed <- review(ctx, data)
+	}()
+
+	var response *admissionv1beta1.AdmissionReview
+	select {
+	case response = <-reviewed:
+	case <-ctx.Done():
+		if path == pathValidatePods {
+			response = w.ValidationDeadlineExceeded(data)
+		} else {
+			response = w.DeadlineExceeded(data)
+		}
+		span.RecordError(ctx.Err())
+	}
+	logger = logger.WithFields(admissionFields(response))
+	span.SetAttribute("admission.decision", webhook.Decision(response))
+	if response.Request != nil {
+		span.SetAttribute("k8s.namespace.name", response.Request.Namespace)
+	}
+
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
-		w.handleRequestError(res, err, http.StatusInternalServerError)
+		requestError(logger, res, err, http.StatusInternalServerError)
 		return
 	}
-	w.Debugf("HTTP Response body: %s", responseJSON)
+	logger.Debugf("HTTP Response body: %s", webhook.RedactJSON(responseJSON))
 
 	if _, err := res.Write(responseJSON); err != nil {
-		w.handleRequestError(res, err, http.StatusInternalServerError)
+		requestError(logger, res, err, http.StatusInternalServerError)
+		return
+	}
+
+	logger = logger.WithField("duration", time.Since(start).String())
+	if webhook.Decision(response) == webhook.DecisionError {
+		logger.Warn("Admission request failed")
 		return
 	}
+	logger.Info("Admission request handled")
+}
+
+// budget returns the latency budget of the admission request req. It's the timeout that the API server sends as the timeout query parameter, or the default admission timeout, less the margin that is needed to send the response back.
+func budget(req *http.Request) time.Duration {
+	timeout := admissionTimeout
+	if t, err := time.ParseDuration(req.URL.Query().Get("timeout")); err == nil && t > 0 {
+		timeout = t
+	}
+
+	margin := timeoutMargin
+	if margin > timeout/2 {
+		margin = timeout / 2
+	}
+
+	return timeout - margin
+}
+
+// admissionFields returns the log fields that identify the admission request of review, and the decision of its response.
+func admissionFields(review *admissionv1beta1.AdmissionReview) logrus.Fields {
+	fields := logrus.Fields{"decision": webhook.Decision(review)}
+	if review.Response != nil && review.Response.Result != nil && review.Response.Result.Message != "" {
+		fields["reason"] = review.Response.Result.Message
+	}
+
+	request := review.Request
+	if request == nil {
+		return fields
+	}
+
+	fields["uid"] = request.UID
+	fields["kind"] = request.Kind.Kind
+	fields["namespace"] = request.Namespace
+	fields["operation"] = request.Operation
+	fields["user"] = request.UserInfo.Username
+	if request.Name != "" {
+		fields["name"] = request.Name
+	}
+
+	// the names of pods created by workload controllers aren't generated until after admission
+	var object struct {
+		Metadata struct {
+			GenerateName string `json:"generateName"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(request.Object.Raw, &object); err == nil && object.Metadata.GenerateName != "" {
+		fields["generateName"] = object.Metadata.GenerateName
+	}
+
+	return fields
 }
 
 func (w *WebhookServer) handleRequestError(res http.ResponseWriter, err error, code int) {
-	w.WithFields(logrus.Fields{
+	requestError(w.Entry, res, err, code)
+}
+
+func requestError(logger *logrus.Entry, res http.ResponseWriter, err error, code int) {
+	logger.WithFields(logrus.Fields{
 		"code": code,
 	}).Error(err)
 	http.Error(res, err.Error(), code)
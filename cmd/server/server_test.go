@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,7 +12,9 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	webhook "github.com/ihcsim/sidecar-injector"
 	"github.com/ihcsim/sidecar-injector/test"
@@ -33,18 +37,22 @@ func TestMain(m *testing.M) {
 	log := logrus.New()
 	log.SetOutput(ioutil.Discard)
 	logger := logrus.NewEntry(log)
-	testServer = &WebhookServer{nil, w, logger}
+	testServer = &WebhookServer{
+		Webhook: w,
+		Entry:   logger,
+		metrics: newMetrics(),
+	}
 
 	os.Exit(m.Run())
 }
 
-func TestServe(t *testing.T) {
+func TestMutatePods(t *testing.T) {
 	t.Run("With Empty HTTP Request Body", func(t *testing.T) {
 		in := bytes.NewReader(nil)
-		request := httptest.NewRequest(http.MethodGet, "/", in)
+		request := httptest.NewRequest(http.MethodGet, pathMutatePods, in)
 
 		recorder := httptest.NewRecorder()
-		testServer.serve(recorder, request)
+		testServer.mutatePods(recorder, request)
 
 		if recorder.Code != http.StatusOK {
 			t.Errorf("HTTP response status mismatch. Expected: %d. Actual: %d", http.StatusOK, recorder.Code)
@@ -62,10 +70,10 @@ func TestServe(t *testing.T) {
 		}
 
 		in := bytes.NewReader(body)
-		request := httptest.NewRequest(http.MethodGet, "/", in)
+		request := httptest.NewRequest(http.MethodGet, pathMutatePods, in)
 
 		recorder := httptest.NewRecorder()
-		testServer.serve(recorder, request)
+		testServer.mutatePods(recorder, request)
 
 		if recorder.Code != http.StatusOK {
 			t.Errorf("HTTP response status mismatch. Expected: %d. Actual: %d", http.StatusOK, recorder.Code)
@@ -93,10 +101,10 @@ func TestServe(t *testing.T) {
 		}
 
 		in := bytes.NewReader(body)
-		request := httptest.NewRequest(http.MethodGet, "/", in)
+		request := httptest.NewRequest(http.MethodGet, pathMutatePods, in)
 
 		recorder := httptest.NewRecorder()
-		testServer.serve(recorder, request)
+		testServer.mutatePods(recorder, request)
 
 		if recorder.Code != http.StatusOK {
 			t.Errorf("HTTP response status mismatch. Expected: %d. Actual: %d", http.StatusOK, recorder.Code)
@@ -118,11 +126,202 @@ func TestServe(t *testing.T) {
 	})
 }
 
+func TestValidatePods(t *testing.T) {
+	testServer.EnforcedNamespaces = []string{test.DefaultNamespace}
+	defer func() {
+		testServer.EnforcedNamespaces = nil
+	}()
+
+	body, err := test.FixtureHTTPRequestBody("http-request-body-valid.json", "../..")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	in := bytes.NewReader(body)
+	request := httptest.NewRequest(http.MethodPost, pathValidatePods, in)
+
+	recorder := httptest.NewRecorder()
+	testServer.validatePods(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("HTTP response status mismatch. Expected: %d. Actual: %d", http.StatusOK, recorder.Code)
+	}
+
+	var actual admissionv1beta1.AdmissionReview
+	if err := json.Unmarshal(recorder.Body.Bytes(), &actual); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if actual.Response == nil || actual.Response.Allowed {
+		t.Errorf("Expected pod without sidecar to be rejected. Actual response: %+v", actual.Response)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	body, err := test.FixtureHTTPRequestBody("http-request-body-valid.json", "../..")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var testCases = []struct {
+		path         string
+		body         []byte
+		expectedCode int
+	}{
+		{path: pathMutatePods, body: body, expectedCode: http.StatusOK},
+		{path: pathLegacy, body: body, expectedCode: http.StatusOK},
+		{path: pathMutateWorkloads, body: body, expectedCode: http.StatusOK},
+		{path: pathValidatePods, body: body, expectedCode: http.StatusOK},
+		{path: pathHealthz, expectedCode: http.StatusOK},
+		{path: pathReadyz, expectedCode: http.StatusOK},
+		{path: pathMetrics, expectedCode: http.StatusOK},
+		{path: "/unknown", expectedCode: http.StatusNotFound},
+	}
+
+	handler := testServer.routes()
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, testCase.path, bytes.NewReader(testCase.body))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != testCase.expectedCode {
+				t.Errorf("HTTP response status mismatch. Expected: %d. Actual: %d", testCase.expectedCode, recorder.Code)
+			}
+		})
+	}
+
+	t.Run("Metrics", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, pathMetrics, nil))
+
+		expected := fmt.Sprintf("sidecar_injector_requests_total{handler=%q,code=\"200\"}", pathMutatePods)
+		if !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("Expected metrics to contain %s. Actual: %s", expected, recorder.Body.String())
+		}
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	testServer.elector = webhook.NewLeaderElector(testServer.Webhook, test.DefaultNamespace, "sidecar-injector-leader", "replica-0")
+	defer func() {
+		testServer.elector = nil
+	}()
+
+	recorder := httptest.NewRecorder()
+	testServer.readyz(recorder, httptest.NewRequest(http.MethodGet, pathReadyz, nil))
+
+	expected := readiness{
+		Ready: true,
+		LeaderElection: &leaderElectionStatus{
+			Identity: "replica-0",
+			IsLeader: false,
+		},
+	}
+
+	var actual readiness
+	if err := json.Unmarshal(recorder.Body.Bytes(), &actual); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+	}
+
+	recorder = httptest.NewRecorder()
+	testServer.serveMetrics(recorder, httptest.NewRequest(http.MethodGet, pathMetrics, nil))
+	if !strings.Contains(recorder.Body.String(), "sidecar_injector_leader 0") {
+		t.Errorf("Expected metrics to contain the leader gauge. Actual: %s", recorder.Body.String())
+	}
+}
+
+func TestAdmitLogging(t *testing.T) {
+	body, err := test.FixtureHTTPRequestBody("http-request-body-valid.json", "../..")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var (
+		out    = &bytes.Buffer{}
+		log    = logrus.New()
+		server = &WebhookServer{
+			Webhook: testServer.Webhook,
+			Entry:   logrus.NewEntry(log),
+			metrics: newMetrics(),
+		}
+		handler = server.routes()
+	)
+	log.Out = out
+	log.Formatter = &logrus.JSONFormatter{}
+	log.SetLevel(logrus.DebugLevel)
+
+	// concurrent requests must not mix their log fields
+	var wg sync.WaitGroup
+	for _, path := range []string{pathMutatePods, pathValidatePods, pathMutatePods, pathValidatePods} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		}(path)
+	}
+	wg.Wait()
+
+	expectedDecisions := map[string]string{
+		pathMutatePods:   webhook.DecisionPatched,
+		pathValidatePods: webhook.DecisionAllowed,
+	}
+
+	var handled, debug int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		switch entry["msg"] {
+		case "Admission request handled":
+			handled++
+			handler, _ := entry["handler"].(string)
+			if actual := entry["decision"]; actual != expectedDecisions[handler] {
+				t.Errorf("Decision mismatch of %s. Expected: %s. Actual: %s", handler, expectedDecisions[handler], actual)
+			}
+
+			expected := map[string]interface{}{
+				"uid":       "505034df-a300-11e8-b3da-c810c860534d",
+				"kind":      "Pod",
+				"namespace": "default",
+				"operation": "CREATE",
+				"user":      "minikube-user",
+			}
+			for key, value := range expected {
+				if entry[key] != value {
+					t.Errorf("Log field %s mismatch. Expected: %s. Actual: %v", key, value, entry[key])
+				}
+			}
+
+			if _, exists := entry["duration"]; !exists {
+				t.Errorf("Expected log entry to have a duration. Actual: %s", line)
+			}
+
+		case "HTTP Request body: " + string(webhook.RedactJSON(body)):
+			debug++
+		}
+	}
+
+	if handled != 4 {
+		t.Errorf("Handled requests mismatch. Expected: 4. Actual: %d", handled)
+	}
+
+	if debug != 4 {
+		t.Errorf("Debug request body logs mismatch. Expected: 4. Actual: %d", debug)
+	}
+}
+
 func TestHandleRequestError(t *testing.T) {
 	var (
 		errMsg   = "Some test error"
 		recorder = httptest.NewRecorder()
-		err      = fmt.Errorf(errMsg)
+		err      = errors.New(errMsg)
 	)
 
 	testServer.handleRequestError(recorder, err, http.StatusInternalServerError)
@@ -213,3 +412,85 @@ func initWebhookWithConfigMap() (*webhook.Webhook, error) {
 
 	return fixture, nil
 }
+
+func TestBudget(t *testing.T) {
+	var testCases = []struct {
+		url      string
+		expected time.Duration
+	}{
+		{url: pathMutatePods, expected: 9 * time.Second},
+		{url: pathMutatePods + "?timeout=30s", expected: 29 * time.Second},
+		{url: pathMutatePods + "?timeout=1s", expected: 500 * time.Millisecond},
+		{url: pathMutatePods + "?timeout=invalid", expected: 9 * time.Second},
+		{url: pathMutatePods + "?timeout=-5s", expected: 9 * time.Second},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.url, func(t *testing.T) {
+			if actual := budget(httptest.NewRequest(http.MethodPost, testCase.url, nil)); actual != testCase.expected {
+				t.Errorf("Budget mismatch. Expected: %s. Actual: %s", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAdmitDeadline(t *testing.T) {
+	body, err := test.FixtureHTTPRequestBody("http-request-body-valid.json", "../..")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	defer func() {
+		testServer.FailOpen = false
+		testServer.ValidationFailOpen = false
+	}()
+
+	// the review outlives the request's deadline
+	release := make(chan struct{})
+	defer close(release)
+	slowReview := func(ctx context.Context, data []byte) *admissionv1beta1.AdmissionReview {
+		<-release
+		return &admissionv1beta1.AdmissionReview{}
+	}
+
+	var testCases = []struct {
+		path               string
+		failOpen           bool
+		validationFailOpen bool
+		expected           string
+	}{
+		{path: pathMutatePods, failOpen: true, expected: webhook.DecisionAllowed},
+		{path: pathMutatePods, failOpen: false, expected: webhook.DecisionError},
+		{path: pathValidatePods, failOpen: true, validationFailOpen: false, expected: webhook.DecisionError},
+		{path: pathValidatePods, failOpen: false, validationFailOpen: true, expected: webhook.DecisionAllowed},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("%s fail-open=%t validation-fail-open=%t", testCase.path, testCase.failOpen, testCase.validationFailOpen), func(t *testing.T) {
+			testServer.FailOpen = testCase.failOpen
+			testServer.ValidationFailOpen = testCase.validationFailOpen
+
+			start := time.Now()
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, testCase.path+"?timeout=200ms", bytes.NewReader(body))
+			testServer.admit(recorder, request, testCase.path, slowReview)
+
+			if elapsed := time.Since(start); elapsed >= time.Second {
+				t.Errorf("Expected response before the review completed. Actual duration: %s", elapsed)
+			}
+
+			var actual admissionv1beta1.AdmissionReview
+			if err := json.Unmarshal(recorder.Body.Bytes(), &actual); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if decision := webhook.Decision(&actual); decision != testCase.expected {
+				t.Errorf("Decision mismatch. Expected: %s. Actual: %s", testCase.expected, decision)
+			}
+
+			if actual.Response.UID != "505034df-a300-11e8-b3da-c810c860534d" || len(actual.Response.Patch) > 0 {
+				t.Errorf("Response mismatch. Actual: %+v", actual.Response)
+			}
+		})
+	}
+}
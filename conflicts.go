@@ -0,0 +1,135 @@
+package injector
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The policies of the sidecar template that decide how the conflicts between the sidecar container and the pod are handled.
+const (
+	// ConflictPolicyReject rejects the pods with conflicts. It's the default policy.
+	ConflictPolicyReject = "reject"
+
+	// ConflictPolicySkip admits the pods with conflicts without the sidecar container.
+	ConflictPolicySkip = "skip"
+
+	// ConflictPolicyRename renames the sidecar container if its name is taken. Conflicts that can't be renamed are rejected.
+	ConflictPolicyRename = "rename"
+)
+
+// annotationKeySidecarContainer is the annotation of the pods whose sidecar container was renamed. It's used to find the sidecar container of the pod.
+const annotationKeySidecarContainer = "sidecar.example.org/container-name"
+
+// Conflict is a conflict between a field of the sidecar container and the pod.
+type Conflict struct {
+	Field   string
+	Message string
+}
+
+func (c Conflict) String() string {
+	return c.Field + ": " + c.Message
+}
+
+// conflicts returns the conflicts between sidecar and the containers and volumes of pod. Besides the container names, container ports and host ports that the API server or the container runtime would reject, the sidecar can't mount volumes that the pod doesn't define, or mount a different volume at a path where another container mounts a volume, as containers that mount the same path are expected to share its content, such as UNIX sockets.
+func conflicts(pod *corev1.Pod, sidecar *corev1.Container) []Conflict {
+	var (
+		result     []Conflict
+		containers = append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		initCount  = len(pod.Spec.InitContainers)
+	)
+
+	for _, container := range containers {
+		if container.Name == sidecar.Name {
+			result = append(result, Conflict{Field: "name", Message: fmt.Sprintf("container %q already exists", sidecar.Name)})
+			break
+		}
+	}
+
+	for i, port := range sidecar.Ports {
+		path := field.NewPath("ports").Index(i)
+		for j, container := range containers {
+			for _, other := range container.Ports {
+				// init containers exit before the other containers start listening on their ports
+				if j >= initCount && other.ContainerPort == port.ContainerPort && protocol(other) == protocol(port) {
+					result = append(result, Conflict{Field: path.Child("containerPort").String(), Message: fmt.Sprintf("port %d/%s is used by container %q", port.ContainerPort, protocol(port), container.Name)})
+				}
+
+				if port.HostPort != 0 && other.HostPort == port.HostPort && protocol(other) == protocol(port) {
+					result = append(result, Conflict{Field: path.Child("hostPort").String(), Message: fmt.Sprintf("host port %d/%s is used by container %q", port.HostPort, protocol(port), container.Name)})
+				}
+			}
+		}
+	}
+
+	volumes := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+
+	for i, mount := range sidecar.VolumeMounts {
+		path := field.NewPath("volumeMounts").Index(i)
+		if !volumes[mount.Name] {
+			result = append(result, Conflict{Field: path.Child("name").String(), Message: fmt.Sprintf("volume %q isn't defined by the pod", mount.Name)})
+		}
+
+		for _, container := range containers {
+			for _, other := range container.VolumeMounts {
+				if other.MountPath == mount.MountPath && other.Name != mount.Name {
+					result = append(result, Conflict{Field: path.Child("mountPath").String(), Message: fmt.Sprintf("path %s is mounted from volume %q by container %q", mount.MountPath, other.Name, container.Name)})
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// resolveConflicts returns the sidecar container to be injected into pod, and its conflicts with pod. With the rename policy, a sidecar container whose name is taken is renamed, and only its other conflicts are returned.
+func resolveConflicts(pod *corev1.Pod, sidecar *corev1.Container, policy string) (*corev1.Container, []Conflict) {
+	result := conflicts(pod, sidecar)
+	if policy != ConflictPolicyRename || len(result) == 0 || result[0].Field != "name" {
+		return sidecar, result
+	}
+
+	taken := map[string]bool{}
+	for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		taken[container.Name] = true
+	}
+
+	renamed := sidecar.DeepCopy()
+	renamed.Name = sidecar.Name + "-sidecar"
+	for i := 2; taken[renamed.Name]; i++ {
+		renamed.Name = fmt.Sprintf("%s-sidecar-%d", sidecar.Name, i)
+	}
+
+	return renamed, result[1:]
+}
+
+// sidecarName returns the name of the sidecar container of pod, which is named name unless it was renamed.
+func sidecarName(pod *corev1.Pod, name string) string {
+	if renamed, exists := pod.ObjectMeta.GetAnnotations()[annotationKeySidecarContainer]; exists && renamed != "" {
+		return renamed
+	}
+
+	return name
+}
+
+func protocol(port corev1.ContainerPort) corev1.Protocol {
+	if port.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+
+	return port.Protocol
+}
+
+func conflictMessage(conflicts []Conflict) string {
+	msgs := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		msgs = append(msgs, conflict.String())
+	}
+
+	return strings.Join(msgs, "; ")
+}
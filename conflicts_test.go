@@ -0,0 +1,290 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type staticSource struct {
+	template *SidecarTemplate
+}
+
+func (s staticSource) Template(ctx context.Context) (*SidecarTemplate, error) {
+	return s.template, nil
+}
+
+func (s staticSource) String() string {
+	return "static"
+}
+
+func TestConflicts(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{Name: "data"}, {Name: "sockets"}},
+			InitContainers: []corev1.Container{
+				{Name: "init", Ports: []corev1.ContainerPort{{ContainerPort: 9090}}},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:         "app",
+					Ports:        []corev1.ContainerPort{{ContainerPort: 80}, {ContainerPort: 53, Protocol: corev1.ProtocolUDP, HostPort: 53}},
+					VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/var/run/app"}},
+				},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		name     string
+		sidecar  corev1.Container
+		expected []string
+	}{
+		{
+			name:    "No conflicts",
+			sidecar: corev1.Container{Name: "nginx", Ports: []corev1.ContainerPort{{ContainerPort: 8080}, {ContainerPort: 80, Protocol: corev1.ProtocolUDP}, {ContainerPort: 9090}}},
+		},
+		{
+			name:     "Container name",
+			sidecar:  corev1.Container{Name: "init"},
+			expected: []string{"name"},
+		},
+		{
+			name:     "Ports",
+			sidecar:  corev1.Container{Name: "nginx", Ports: []corev1.ContainerPort{{ContainerPort: 80, Protocol: corev1.ProtocolTCP}, {ContainerPort: 5353, HostPort: 53, Protocol: corev1.ProtocolUDP}}},
+			expected: []string{"ports[0].containerPort", "ports[1].hostPort"},
+		},
+		{
+			name: "Volumes",
+			sidecar: corev1.Container{Name: "nginx", VolumeMounts: []corev1.VolumeMount{
+				{Name: "data", MountPath: "/var/run/app"},
+				{Name: "cache", MountPath: "/var/cache"},
+				{Name: "sockets", MountPath: "/var/run/app"},
+			}},
+			expected: []string{"volumeMounts[1].name", "volumeMounts[2].mountPath"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual := []string{}
+			for _, conflict := range conflicts(pod, &testCase.sidecar) {
+				actual = append(actual, conflict.Field)
+			}
+
+			expected := testCase.expected
+			if expected == nil {
+				expected = []string{}
+			}
+
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx"}, {Name: "nginx-sidecar", Ports: []corev1.ContainerPort{{ContainerPort: 80}}}},
+		},
+	}
+
+	var testCases = []struct {
+		name      string
+		sidecar   *corev1.Container
+		policy    string
+		expected  string
+		conflicts int
+	}{
+		{name: "Reject", sidecar: &corev1.Container{Name: "nginx"}, policy: ConflictPolicyReject, expected: "nginx", conflicts: 1},
+		{name: "Skip", sidecar: &corev1.Container{Name: "nginx"}, policy: ConflictPolicySkip, expected: "nginx", conflicts: 1},
+		{name: "Rename", sidecar: &corev1.Container{Name: "nginx"}, policy: ConflictPolicyRename, expected: "nginx-sidecar-2"},
+		{name: "Rename with port conflict", sidecar: &corev1.Container{Name: "nginx", Ports: []corev1.ContainerPort{{ContainerPort: 80}}}, policy: ConflictPolicyRename, expected: "nginx-sidecar-2", conflicts: 1},
+		{name: "Rename without conflicts", sidecar: &corev1.Container{Name: "proxy"}, policy: ConflictPolicyRename, expected: "proxy"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			name := testCase.sidecar.Name
+			actual, conflicts := resolveConflicts(pod, testCase.sidecar, testCase.policy)
+			if actual.Name != testCase.expected {
+				t.Errorf("Name mismatch. Expected: %s. Actual: %s", testCase.expected, actual.Name)
+			}
+
+			if len(conflicts) != testCase.conflicts {
+				t.Errorf("Conflicts mismatch. Expected: %d. Actual: %s", testCase.conflicts, conflictMessage(conflicts))
+			}
+
+			if testCase.sidecar.Name != name {
+				t.Errorf("Expected the sidecar to be unchanged. Actual: %s", testCase.sidecar.Name)
+			}
+		})
+	}
+}
+
+func TestInjectPodSpecConflicts(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx"}},
+		},
+	}
+
+	var testCases = []struct {
+		policy      string
+		allowed     bool
+		patched     bool
+		annotations map[string]string
+	}{
+		{policy: "", allowed: false},
+		{policy: ConflictPolicySkip, allowed: true},
+		{
+			policy:  ConflictPolicyRename,
+			allowed: true,
+			patched: true,
+			annotations: map[string]string{
+				annotationKeySidecarInjection: "false",
+				annotationKeySidecarVersion:   "v1",
+				annotationKeySidecarContainer: "nginx-sidecar",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.policy, func(t *testing.T) {
+			fixture := *webhook
+			fixture.TemplateSource = staticSource{template: &SidecarTemplate{
+				Container:      corev1.Container{Name: "nginx", Image: "nginx"},
+				Version:        "v1",
+				ConflictPolicy: testCase.policy,
+			}}
+
+			actual, err := fixture.injectPodSpec(context.Background(), "uid", NewPodPatch(pod))
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if actual.Allowed != testCase.allowed {
+				t.Errorf("Boolean mismatch. Expected: %t. Actual: %t", testCase.allowed, actual.Allowed)
+			}
+
+			if !testCase.allowed && (actual.Result == nil || actual.Result.Reason != metav1.StatusReasonForbidden) {
+				t.Errorf("Expected forbidden result. Actual: %+v", actual.Result)
+			}
+
+			if !testCase.patched {
+				if actual.Patch != nil {
+					t.Errorf("Expected no patch. Actual: %s", actual.Patch)
+				}
+				return
+			}
+
+			var (
+				patched = pod.DeepCopy()
+				ops     []struct {
+					Path  string          `json:"path"`
+					Value json.RawMessage `json:"value"`
+				}
+			)
+			if err := json.Unmarshal(actual.Patch, &ops); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			for _, op := range ops {
+				switch {
+				case op.Path == patchPathContainer:
+					var container corev1.Container
+					if err := json.Unmarshal(op.Value, &container); err != nil {
+						t.Fatal("Unexpected error: ", err)
+					}
+					patched.Spec.Containers = append(patched.Spec.Containers, container)
+
+				case op.Path == patchPathAnnotation:
+					if err := json.Unmarshal(op.Value, &patched.Annotations); err != nil {
+						t.Fatal("Unexpected error: ", err)
+					}
+
+				default:
+					var value string
+					if err := json.Unmarshal(op.Value, &value); err != nil {
+						t.Fatal("Unexpected error: ", err)
+					}
+					patched.Annotations[annotationKeySidecarContainer] = value
+				}
+			}
+
+			if name := patched.Spec.Containers[1].Name; name != "nginx-sidecar" {
+				t.Errorf("Name mismatch. Expected: nginx-sidecar. Actual: %s", name)
+			}
+
+			if !reflect.DeepEqual(testCase.annotations, patched.Annotations) {
+				t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", testCase.annotations, patched.Annotations)
+			}
+		})
+	}
+}
+
+func TestUpgradeWorkloadRenamedSidecar(t *testing.T) {
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationKeySidecarInjection: "false",
+			annotationKeySidecarVersion:   "v1",
+			annotationKeySidecarContainer: "nginx-sidecar",
+		}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx"}, {Name: "nginx-sidecar", Image: "nginx:1.14"}},
+		},
+	}
+
+	hash, err := sidecarHash(&template.Spec.Containers[1])
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	template.ObjectMeta.Annotations[annotationKeySidecarHash] = hash
+
+	raw, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": template}})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	request := &admissionv1beta1.AdmissionRequest{
+		UID:       "uid",
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Operation: admissionv1beta1.Update,
+	}
+	request.Object.Raw = raw
+	request.OldObject.Raw = raw
+
+	fixture := *webhook
+	fixture.TemplateSource = staticSource{template: &SidecarTemplate{
+		Container:      corev1.Container{Name: "nginx", Image: "nginx:1.15"},
+		Version:        "v2",
+		ConflictPolicy: ConflictPolicyRename,
+	}}
+
+	actual, err := fixture.upgradeWorkload(context.Background(), request, NewPodTemplatePatch(&template, patchPrefixPodTemplate))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var ops []*patchOp
+	if err := json.Unmarshal(actual.Patch, &ops); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if len(ops) == 0 || ops[0].Path != "/spec/template/spec/containers/1" {
+		t.Fatalf("Expected the renamed sidecar to be replaced. Actual: %s", actual.Patch)
+	}
+
+	container := ops[0].Value.(map[string]interface{})
+	if container["name"] != "nginx-sidecar" || container["image"] != "nginx:1.15" {
+		t.Errorf("Content mismatch\nExpected: nginx-sidecar (nginx:1.15)\nActual: %+v", container)
+	}
+}
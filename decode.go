@@ -0,0 +1,865 @@
+package injector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// TemplateError is an error of a sidecar template field. Line is the line of the field in the template source, or 0 if the field can't be located.
+type TemplateError struct {
+	Field   string
+	Line    int
+	Message string
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// TemplateErrors are all the errors of an invalid sidecar template.
+type TemplateErrors []*TemplateError
+
+func (e TemplateErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return "Invalid sidecar template: " + strings.Join(msgs, "; ")
+}
+
+// DecodeTemplate decodes the YAML or JSON sidecar template in data. Unlike json.Unmarshal, it rejects unknown fields, including fields whose case doesn't match, and validates the container with a subset of the Kubernetes container validation rules. Schema and validation errors are returned as TemplateErrors, with their field paths and lines.
+func DecodeTemplate(data []byte) (*SidecarTemplate, error) {
+	b, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sidecar template: %s", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Invalid sidecar template: %s", err)
+	}
+
+	if _, ok := doc.(map[string]interface{}); !ok && doc != nil {
+		return nil, fmt.Errorf("Invalid sidecar template: expected an object")
+	}
+
+	errs := checkFields(doc, reflect.TypeOf(SidecarTemplate{}), nil)
+	if len(errs) == 0 {
+		var t SidecarTemplate
+		if err := json.Unmarshal(b, &t); err != nil {
+			return nil, fmt.Errorf("Invalid sidecar template: %s", err)
+		}
+
+		for _, err := range validateTemplate(&t) {
+			errs = append(errs, &TemplateError{Field: err.Field, Message: err.ErrorBody()})
+		}
+
+		if len(errs) == 0 {
+			return &t, nil
+		}
+	}
+
+	for _, err := range errs {
+		err.Line = locate(data, err.Field)
+	}
+
+	return nil, errs
+}
+
+// checkFields checks that the keys of the decoded JSON value match the JSON fields of t exactly, and that the values have the expected JSON types.
+func checkFields(value interface{}, t reflect.Type, path *field.Path) TemplateErrors {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if value == nil || reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return nil
+	}
+
+	var errs TemplateErrors
+	invalid := func(expected string) TemplateErrors {
+		return TemplateErrors{{Field: path.String(), Message: "expected " + expected}}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("an object")
+		}
+
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			child := childPath(path, key)
+			fieldType, exists := fields[key]
+			if !exists {
+				errs = append(errs, &TemplateError{Field: child.String(), Message: unknownField(key, fields)})
+				continue
+			}
+			errs = append(errs, checkFields(obj[key], fieldType, child)...)
+		}
+
+	case reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("an object")
+		}
+
+		for _, key := range sortedKeys(obj) {
+			errs = append(errs, checkFields(obj[key], t.Elem(), path.Key(key))...)
+		}
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if _, ok := value.(string); !ok {
+				return invalid("a base64 string")
+			}
+			return nil
+		}
+
+		list, ok := value.([]interface{})
+		if !ok {
+			return invalid("a list")
+		}
+
+		for i, item := range list {
+			errs = append(errs, checkFields(item, t.Elem(), path.Index(i))...)
+		}
+
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return invalid("a string")
+		}
+
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return invalid("a boolean")
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := value.(json.Number)
+		if !ok {
+			return invalid("an integer")
+		}
+		if _, err := strconv.ParseInt(string(n), 10, 64); err != nil {
+			return invalid("an integer")
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			return invalid("a number")
+		}
+	}
+
+	return errs
+}
+
+// jsonFields returns the types of the JSON fields of the struct type t, including the fields of its inlined structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if name == "" && (f.Anonymous || strings.Contains(tag, ",inline")) {
+			embedded := f.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				// fields of the outer struct take precedence over the inlined fields
+				for key, fieldType := range jsonFields(embedded) {
+					if _, exists := fields[key]; !exists {
+						fields[key] = fieldType
+					}
+				}
+				continue
+			}
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+func unknownField(key string, fields map[string]reflect.Type) string {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return fmt.Sprintf("unknown field, did you mean %q?", name)
+		}
+	}
+
+	return "unknown field"
+}
+
+func childPath(path *field.Path, name string) *field.Path {
+	if path == nil {
+		return field.NewPath(name)
+	}
+
+	return path.Child(name)
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// validateTemplate validates the container of t with the subset of the Kubernetes container validation rules that don't depend on the pod, and the directives of t.
+func validateTemplate(t *SidecarTemplate) field.ErrorList {
+	if t.Overlay != nil {
+		return validateOverlay(t)
+	}
+
+	errs := validateContainer(&t.Container, nil, true)
+
+	if t.DefaultResources != nil {
+		for _, name := range sortedResourceNames(t.DefaultResources.Limits) {
+			limit := t.DefaultResources.Limits[name]
+			if request, exists := t.DefaultResources.Requests[name]; exists && request.Cmp(limit) > 0 {
+				errs = append(errs, field.Invalid(field.NewPath("defaultResources", "requests").Key(string(name)), request.String(), fmt.Sprintf("must be less than or equal to %s limit", name)))
+			}
+		}
+	}
+
+	for i, name := range t.InheritEnv {
+		for _, msg := range validation.IsEnvVarName(name) {
+			errs = append(errs, field.Invalid(field.NewPath("inheritEnv").Index(i), name, msg))
+		}
+	}
+
+	if t.Startup != nil {
+		errs = append(errs, validateStartup(t)...)
+	}
+
+	if t.Shutdown != nil {
+		errs = append(errs, validateShutdown(t)...)
+	}
+
+	if t.Pod != nil {
+		errs = append(errs, validatePodMutations(t.Pod, field.NewPath("pod"))...)
+	}
+
+	switch t.ConflictPolicy {
+	case "", ConflictPolicyReject, ConflictPolicySkip, ConflictPolicyRename:
+	default:
+		errs = append(errs, field.NotSupported(field.NewPath("conflictPolicy"), t.ConflictPolicy, []string{ConflictPolicyReject, ConflictPolicySkip, ConflictPolicyRename}))
+	}
+
+	return errs
+}
+
+// validateContainer validates container, whose field path is path, with the subset of the Kubernetes container validation rules that don't depend on the pod. The image is only required if requireImage is true.
+func validateContainer(container *corev1.Container, path *field.Path, requireImage bool) field.ErrorList {
+	var errs field.ErrorList
+	if container.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(container.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), container.Name, msg))
+		}
+	}
+
+	if container.Image == "" && requireImage {
+		errs = append(errs, field.Required(path.Child("image"), ""))
+	}
+
+	switch container.ImagePullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("imagePullPolicy"), container.ImagePullPolicy, []string{string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever)}))
+	}
+
+	portNames := map[string]bool{}
+	for i, port := range container.Ports {
+		path := path.Child("ports").Index(i)
+		if port.Name != "" {
+			for _, msg := range validation.IsValidPortName(port.Name) {
+				errs = append(errs, field.Invalid(path.Child("name"), port.Name, msg))
+			}
+
+			if portNames[port.Name] {
+				errs = append(errs, field.Duplicate(path.Child("name"), port.Name))
+			}
+			portNames[port.Name] = true
+		}
+
+		for _, msg := range validation.IsValidPortNum(int(port.ContainerPort)) {
+			errs = append(errs, field.Invalid(path.Child("containerPort"), port.ContainerPort, msg))
+		}
+
+		if port.HostPort != 0 {
+			for _, msg := range validation.IsValidPortNum(int(port.HostPort)) {
+				errs = append(errs, field.Invalid(path.Child("hostPort"), port.HostPort, msg))
+			}
+		}
+
+		switch port.Protocol {
+		case "", corev1.ProtocolTCP, corev1.ProtocolUDP:
+		default:
+			errs = append(errs, field.NotSupported(path.Child("protocol"), port.Protocol, []string{string(corev1.ProtocolTCP), string(corev1.ProtocolUDP)}))
+		}
+	}
+
+	for i, env := range container.Env {
+		path := path.Child("env").Index(i)
+		for _, msg := range validation.IsEnvVarName(env.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), env.Name, msg))
+		}
+
+		if env.Value != "" && env.ValueFrom != nil {
+			errs = append(errs, field.Invalid(path.Child("valueFrom"), "", "may not be specified when `value` is not empty"))
+		}
+	}
+
+	mountPaths := map[string]bool{}
+	for i, mount := range container.VolumeMounts {
+		path := path.Child("volumeMounts").Index(i)
+		if mount.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		}
+
+		if mount.MountPath == "" {
+			errs = append(errs, field.Required(path.Child("mountPath"), ""))
+		} else if mountPaths[mount.MountPath] {
+			errs = append(errs, field.Duplicate(path.Child("mountPath"), mount.MountPath))
+		}
+		mountPaths[mount.MountPath] = true
+	}
+
+	for _, name := range sortedResourceNames(container.Resources.Limits) {
+		limit := container.Resources.Limits[name]
+		if request, exists := container.Resources.Requests[name]; exists && request.Cmp(limit) > 0 {
+			errs = append(errs, field.Invalid(path.Child("resources", "requests").Key(string(name)), request.String(), fmt.Sprintf("must be less than or equal to %s limit", name)))
+		}
+	}
+
+	return errs
+}
+
+// validateOverlay validates the overlay of t. The overlay sets the pod spec, so it can't be combined with the sidecar container fields, their directives, or the pod-level settings other than labels and annotations. The images of the overlay containers are optional, as they can be merged into the containers of the pod.
+func validateOverlay(t *SidecarTemplate) field.ErrorList {
+	var (
+		errs field.ErrorList
+		path = field.NewPath("overlay")
+	)
+
+	if !reflect.DeepEqual(t.Container, corev1.Container{}) {
+		errs = append(errs, field.Forbidden(path, "can't be combined with the sidecar container fields"))
+	}
+
+	for _, directive := range []struct {
+		name string
+		set  bool
+	}{
+		{name: "inheritEnv", set: len(t.InheritEnv) > 0},
+		{name: "inheritResources", set: t.InheritResources},
+		{name: "inheritSecurityContext", set: t.InheritSecurityContext},
+		{name: "defaultResources", set: t.DefaultResources != nil},
+		{name: "conflictPolicy", set: t.ConflictPolicy != ""},
+		{name: "startup", set: t.Startup != nil},
+		{name: "shutdown", set: t.Shutdown != nil},
+	} {
+		if directive.set {
+			errs = append(errs, field.Forbidden(field.NewPath(directive.name), "can't be combined with the overlay"))
+		}
+	}
+
+	if t.Pod != nil {
+		errs = append(errs, validatePodMutations(t.Pod, field.NewPath("pod"))...)
+
+		spec := *t.Pod
+		spec.Labels, spec.Annotations = nil, nil
+		if !reflect.DeepEqual(spec, PodMutations{}) {
+			errs = append(errs, field.Forbidden(field.NewPath("pod"), "only labels and annotations can be combined with the overlay"))
+		}
+	}
+
+	names := map[string]bool{}
+	for _, list := range []struct {
+		name       string
+		containers []corev1.Container
+	}{
+		{name: "initContainers", containers: t.Overlay.InitContainers},
+		{name: "containers", containers: t.Overlay.Containers},
+	} {
+		for i := range list.containers {
+			container := &list.containers[i]
+			errs = append(errs, validateContainer(container, path.Child(list.name).Index(i), false)...)
+
+			if names[container.Name] {
+				errs = append(errs, field.Duplicate(path.Child(list.name).Index(i).Child("name"), container.Name))
+			}
+			names[container.Name] = true
+		}
+	}
+
+	volumes := map[string]bool{}
+	for i, volume := range t.Overlay.Volumes {
+		volumePath := path.Child("volumes").Index(i).Child("name")
+		if volume.Name == "" {
+			errs = append(errs, field.Required(volumePath, ""))
+		} else {
+			for _, msg := range validation.IsDNS1123Label(volume.Name) {
+				errs = append(errs, field.Invalid(volumePath, volume.Name, msg))
+			}
+		}
+
+		if volumes[volume.Name] {
+			errs = append(errs, field.Duplicate(volumePath, volume.Name))
+		}
+		volumes[volume.Name] = true
+	}
+
+	return errs
+}
+
+// validateStartup validates the startup ordering of t. Unless a command is given, the sidecar must have a readiness probe to wait for.
+func validateStartup(t *SidecarTemplate) field.ErrorList {
+	var (
+		errs field.ErrorList
+		path = field.NewPath("startup")
+	)
+
+	if t.Startup.TimeoutSeconds < 0 {
+		errs = append(errs, field.Invalid(path.Child("timeoutSeconds"), t.Startup.TimeoutSeconds, "must be greater than or equal to 0"))
+	}
+
+	if !t.Startup.HoldApplication {
+		return errs
+	}
+
+	if t.Lifecycle != nil && t.Lifecycle.PostStart != nil {
+		errs = append(errs, field.Forbidden(path.Child("holdApplication"), "can't be combined with lifecycle.postStart"))
+	}
+
+	if len(t.Startup.Command) > 0 {
+		return errs
+	}
+
+	probe := t.ReadinessProbe
+	switch {
+	case probe == nil || probe.Exec == nil && probe.HTTPGet == nil && probe.TCPSocket == nil:
+		errs = append(errs, field.Required(path.Child("command"), "required if the sidecar has no readiness probe"))
+	case probe.HTTPGet != nil:
+		if _, err := probePort(&t.Container, probe.HTTPGet.Port); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("readinessProbe", "httpGet", "port"), probe.HTTPGet.Port.String(), "must be the name of a sidecar port"))
+		}
+	case probe.TCPSocket != nil:
+		if _, err := probePort(&t.Container, probe.TCPSocket.Port); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("readinessProbe", "tcpSocket", "port"), probe.TCPSocket.Port.String(), "must be the name of a sidecar port"))
+		}
+	}
+
+	return errs
+}
+
+// validateShutdown validates the drain delays of t. The preStop hook of the sidecar can't be set by both the template and the drain delay, and the sidecar's delay must outlast the application's.
+func validateShutdown(t *SidecarTemplate) field.ErrorList {
+	var (
+		errs field.ErrorList
+		path = field.NewPath("shutdown")
+	)
+
+	if t.Shutdown.DrainSeconds < 0 {
+		errs = append(errs, field.Invalid(path.Child("drainSeconds"), t.Shutdown.DrainSeconds, "must be greater than or equal to 0"))
+	}
+
+	if t.Shutdown.ApplicationDrainSeconds < 0 {
+		errs = append(errs, field.Invalid(path.Child("applicationDrainSeconds"), t.Shutdown.ApplicationDrainSeconds, "must be greater than or equal to 0"))
+	}
+
+	if t.Shutdown.DrainSeconds > 0 && t.Shutdown.ApplicationDrainSeconds > 0 && t.Shutdown.DrainSeconds <= t.Shutdown.ApplicationDrainSeconds {
+		errs = append(errs, field.Invalid(path.Child("drainSeconds"), t.Shutdown.DrainSeconds, "must be greater than applicationDrainSeconds"))
+	}
+
+	if t.Shutdown.DrainSeconds > 0 && t.Lifecycle != nil && t.Lifecycle.PreStop != nil {
+		errs = append(errs, field.Forbidden(path.Child("drainSeconds"), "can't be combined with lifecycle.preStop"))
+	}
+
+	return errs
+}
+
+// validatePodMutations validates the pod-level settings of the template. The annotations of the webhook can't be set by the template.
+func validatePodMutations(m *PodMutations, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, key := range sortedStringKeys(m.Labels) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(path.Child("labels").Key(key), key, msg))
+		}
+
+		for _, msg := range validation.IsValidLabelValue(m.Labels[key]) {
+			errs = append(errs, field.Invalid(path.Child("labels").Key(key), m.Labels[key], msg))
+		}
+	}
+
+	for _, key := range sortedStringKeys(m.Annotations) {
+		for _, msg := range validation.IsQualifiedName(strings.ToLower(key)) {
+			errs = append(errs, field.Invalid(path.Child("annotations").Key(key), key, msg))
+		}
+
+		if strings.HasPrefix(key, templateGroup+"/") {
+			errs = append(errs, field.Forbidden(path.Child("annotations").Key(key), "annotations of the "+templateGroup+" prefix are reserved for the webhook"))
+		}
+	}
+
+	if m.DNSConfig != nil {
+		for i, nameserver := range m.DNSConfig.Nameservers {
+			for _, msg := range validation.IsValidIP(nameserver) {
+				errs = append(errs, field.Invalid(path.Child("dnsConfig", "nameservers").Index(i), nameserver, msg))
+			}
+		}
+
+		for i, option := range m.DNSConfig.Options {
+			if option.Name == "" {
+				errs = append(errs, field.Required(path.Child("dnsConfig", "options").Index(i).Child("name"), ""))
+			}
+		}
+	}
+
+	for i, alias := range m.HostAliases {
+		for _, msg := range validation.IsValidIP(alias.IP) {
+			errs = append(errs, field.Invalid(path.Child("hostAliases").Index(i).Child("ip"), alias.IP, msg))
+		}
+	}
+
+	for i, secret := range m.ImagePullSecrets {
+		if secret.Name == "" {
+			errs = append(errs, field.Required(path.Child("imagePullSecrets").Index(i).Child("name"), ""))
+		}
+	}
+
+	if m.ServiceAccountName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(m.ServiceAccountName) {
+			errs = append(errs, field.Invalid(path.Child("serviceAccountName"), m.ServiceAccountName, msg))
+		}
+	}
+
+	return errs
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedResourceNames(resources corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	return names
+}
+
+// locate returns the line of the field at path in the YAML or JSON source, or 0 if the field can't be located. JSON sources are scanned exactly. Only the block style of YAML is supported.
+func locate(source []byte, path string) int {
+	segments := pathSegments(path)
+	if trimmed := bytes.TrimSpace(source); len(trimmed) > 0 && trimmed[0] == '{' {
+		l := &jsonLocator{data: source, line: 1, target: segments}
+		l.value(nil)
+		return l.found
+	}
+
+	return locateYAML(source, segments)
+}
+
+// pathSegments splits a field path such as ports[0].name into its keys and indices e.g. ports, [0] and name.
+func pathSegments(path string) []string {
+	var segments []string
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return append(segments, path)
+			}
+			segments = append(segments, path[:end+1])
+			path = path[end+1:]
+
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			segments = append(segments, path[:end])
+			path = path[end:]
+		}
+	}
+
+	return segments
+}
+
+// segmentMatches returns true if the object key or list index segment of the source matches the segment of the target path. Map keys are enclosed in brackets in the target path.
+func segmentMatches(segment, target string) bool {
+	return segment == target || "["+segment+"]" == target
+}
+
+func pathMatches(path, target []string) bool {
+	if len(path) != len(target) {
+		return false
+	}
+
+	for i := range path {
+		if !segmentMatches(path[i], target[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// jsonLocator scans a JSON document for the line of the target path.
+type jsonLocator struct {
+	data   []byte
+	pos    int
+	line   int
+	target []string
+	found  int
+}
+
+func (l *jsonLocator) record(path []string) {
+	if l.found == 0 && pathMatches(path, l.target) {
+		l.found = l.line
+	}
+}
+
+func (l *jsonLocator) skipSpace() {
+	for l.pos < len(l.data) {
+		switch l.data[l.pos] {
+		case '\n':
+			l.line++
+		case ' ', '\t', '\r':
+		default:
+			return
+		}
+		l.pos++
+	}
+}
+
+func (l *jsonLocator) value(path []string) {
+	l.skipSpace()
+	if l.pos >= len(l.data) || l.found > 0 {
+		return
+	}
+
+	switch l.data[l.pos] {
+	case '{':
+		l.object(path)
+	case '[':
+		l.array(path)
+	case '"':
+		l.str()
+	default:
+		for l.pos < len(l.data) && !strings.ContainsRune(",}] \t\r\n", rune(l.data[l.pos])) {
+			l.pos++
+		}
+	}
+}
+
+func (l *jsonLocator) object(path []string) {
+	l.pos++
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) || l.data[l.pos] != '"' {
+			if l.pos < len(l.data) && l.data[l.pos] == '}' {
+				l.pos++
+			}
+			return
+		}
+
+		child := append(path[:len(path):len(path)], l.str())
+		l.record(child)
+
+		l.skipSpace()
+		if l.pos >= len(l.data) || l.data[l.pos] != ':' {
+			return
+		}
+		l.pos++
+		l.value(child)
+
+		l.skipSpace()
+		if l.pos >= len(l.data) || l.data[l.pos] != ',' {
+			if l.pos < len(l.data) && l.data[l.pos] == '}' {
+				l.pos++
+			}
+			return
+		}
+		l.pos++
+	}
+}
+
+func (l *jsonLocator) array(path []string) {
+	l.pos++
+	for i := 0; ; i++ {
+		l.skipSpace()
+		if l.pos >= len(l.data) || l.data[l.pos] == ']' {
+			l.pos++
+			return
+		}
+
+		child := append(path[:len(path):len(path)], fmt.Sprintf("[%d]", i))
+		l.record(child)
+		l.value(child)
+
+		l.skipSpace()
+		if l.pos >= len(l.data) || l.data[l.pos] != ',' {
+			l.pos++
+			return
+		}
+		l.pos++
+	}
+}
+
+// str scans the JSON string at the current position, and returns its value.
+func (l *jsonLocator) str() string {
+	start := l.pos
+	for l.pos++; l.pos < len(l.data); l.pos++ {
+		if l.data[l.pos] == '\\' {
+			l.pos++
+			continue
+		}
+
+		if l.data[l.pos] == '"' {
+			l.pos++
+			break
+		}
+	}
+
+	var s string
+	json.Unmarshal(l.data[start:l.pos], &s)
+	return s
+}
+
+// locateYAML scans the block style YAML source for the line of the target path. Flow style values aren't scanned.
+func locateYAML(source []byte, target []string) int {
+	type frame struct {
+		indent  int
+		segment string
+		item    bool
+		items   int
+	}
+
+	var (
+		stack       []*frame
+		blockIndent = -1
+	)
+	path := func() []string {
+		segments := make([]string, 0, len(stack))
+		for _, f := range stack {
+			segments = append(segments, f.segment)
+		}
+		return segments
+	}
+
+	for i, line := range strings.Split(string(source), "\n") {
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		content = strings.TrimSpace(content)
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+
+		// skip the content of block scalars
+		if blockIndent >= 0 {
+			if indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+
+		for strings.HasPrefix(content, "- ") || content == "-" {
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.indent < indent || (top.indent == indent && !top.item) {
+					break
+				}
+				stack = stack[:len(stack)-1]
+			}
+
+			index := 0
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				index = parent.items
+				parent.items++
+			}
+			stack = append(stack, &frame{indent: indent, segment: fmt.Sprintf("[%d]", index), item: true})
+			if pathMatches(path(), target) {
+				return i + 1
+			}
+
+			rest := strings.TrimPrefix(content, "-")
+			trimmed := strings.TrimLeft(rest, " ")
+			indent += 1 + len(rest) - len(trimmed)
+			content = trimmed
+		}
+
+		colon := strings.Index(content, ":")
+		if colon <= 0 || (colon+1 < len(content) && content[colon+1] != ' ') || strings.ContainsAny(content[:1], "{[") {
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		key := strings.Trim(strings.TrimSpace(content[:colon]), `"'`)
+		stack = append(stack, &frame{indent: indent, segment: key})
+		if pathMatches(path(), target) {
+			return i + 1
+		}
+
+		if value := strings.TrimSpace(content[colon+1:]); strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,245 @@
+package injector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ihcsim/sidecar-injector/test"
+)
+
+func TestDecodeTemplate(t *testing.T) {
+	expected, err := test.FixtureContainer(".", "sidecar-container.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var testCases = []struct {
+		name     string
+		data     string
+		expected []TemplateError
+	}{
+		{
+			name: "JSON",
+			data: `{"name": "nginx", "image": "nginx", "ports": [{"name": "http", "containerPort": 80}]}`,
+		},
+		{
+			name: "YAML",
+			data: `
+name: nginx
+image: nginx
+ports:
+- name: http
+  containerPort: 80
+`,
+		},
+		{
+			name: "JSON with misspelled field",
+			data: `{
+  "name": "nginx",
+  "image": "nginx",
+  "ports": [{"name": "http"}, {
+    "name": "metrics",
+    "containerport": 9090
+  }]
+}`,
+			expected: []TemplateError{
+				{Field: "ports[1].containerport", Line: 6, Message: `unknown field, did you mean "containerPort"?`},
+			},
+		},
+		{
+			name: "YAML with unknown fields and types",
+			data: `
+name: nginx
+image: nginx
+ports:
+  - name: http
+    containerport: 80
+inheritResources: "yes"
+sidecar: true
+`,
+			expected: []TemplateError{
+				{Field: "inheritResources", Line: 7, Message: "expected a boolean"},
+				{Field: "ports[0].containerport", Line: 6, Message: `unknown field, did you mean "containerPort"?`},
+				{Field: "sidecar", Line: 8, Message: "unknown field"},
+			},
+		},
+		{
+			name: "YAML with invalid container",
+			data: `
+name: Nginx
+image: nginx
+command:
+- sh
+- -c
+- |
+  name: ignored
+ports:
+- name: http
+  containerPort: 80
+- name: http
+  containerPort: 70000
+resources:
+  requests:
+    cpu: 200m
+  limits:
+    cpu: 100m
+`,
+			expected: []TemplateError{
+				{Field: "name", Line: 2},
+				{Field: "ports[1].name", Line: 12},
+				{Field: "ports[1].containerPort", Line: 13},
+				{Field: "resources.requests[cpu]", Line: 16},
+			},
+		},
+		{
+			name: "YAML with invalid pod settings",
+			data: `
+name: nginx
+image: nginx
+pod:
+  labels:
+    mesh: not valid
+  annotations:
+    sidecar.example.org/inject: "false"
+  hostAliases:
+  - ip: localhost
+  serviceAccountName: Proxy
+`,
+			expected: []TemplateError{
+				{Field: "pod.labels[mesh]", Line: 6},
+				{Field: "pod.annotations[sidecar.example.org/inject]", Line: 8},
+				{Field: "pod.hostAliases[0].ip", Line: 10},
+				{Field: "pod.serviceAccountName", Line: 11},
+			},
+		},
+		{
+			name: "YAML with invalid overlay",
+			data: `
+image: nginx
+conflictPolicy: skip
+overlay:
+  containers:
+  - name: proxy
+  - name: proxy
+  volumes:
+  - name: Config
+pod:
+  serviceAccountName: proxy
+`,
+			expected: []TemplateError{
+				{Field: "overlay", Line: 4},
+				{Field: "conflictPolicy", Line: 3},
+				{Field: "pod", Line: 10},
+				{Field: "overlay.containers[1].name", Line: 7},
+				{Field: "overlay.volumes[0].name", Line: 9},
+			},
+		},
+		{
+			name: "YAML with invalid startup",
+			data: `
+name: nginx
+image: nginx
+readinessProbe:
+  httpGet:
+    port: http
+lifecycle:
+  postStart:
+    exec:
+      command: ["true"]
+startup:
+  holdApplication: true
+  timeoutSeconds: -1
+`,
+			expected: []TemplateError{
+				{Field: "startup.timeoutSeconds", Line: 13},
+				{Field: "startup.holdApplication", Line: 12},
+				{Field: "readinessProbe.httpGet.port", Line: 6},
+			},
+		},
+		{
+			name: "YAML with invalid shutdown",
+			data: `
+name: nginx
+image: nginx
+lifecycle:
+  preStop:
+    exec:
+      command: ["/bin/drain"]
+shutdown:
+  drainSeconds: 10
+  applicationDrainSeconds: -5
+`,
+			expected: []TemplateError{
+				{Field: "shutdown.applicationDrainSeconds", Line: 10},
+				{Field: "shutdown.drainSeconds", Line: 9},
+			},
+		},
+		{
+			name: "YAML with shorter sidecar drain",
+			data: `
+name: nginx
+image: nginx
+shutdown:
+  drainSeconds: 5
+  applicationDrainSeconds: 5
+`,
+			expected: []TemplateError{
+				{Field: "shutdown.drainSeconds", Line: 5},
+			},
+		},
+		{
+			name: "Empty",
+			data: ``,
+			expected: []TemplateError{
+				{Field: "name", Message: "Required value"},
+				{Field: "image", Message: "Required value"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, err := DecodeTemplate([]byte(testCase.data))
+			if len(testCase.expected) == 0 {
+				if err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+
+				if !reflect.DeepEqual(expected, &actual.Container) {
+					t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual.Container)
+				}
+				return
+			}
+
+			errs, ok := err.(TemplateErrors)
+			if !ok {
+				t.Fatalf("Expected template errors. Actual: %v", err)
+			}
+
+			if len(errs) != len(testCase.expected) {
+				t.Fatalf("Errors mismatch\nExpected: %+v\nActual: %s", testCase.expected, errs)
+			}
+
+			for i, expected := range testCase.expected {
+				if errs[i].Field != expected.Field || errs[i].Line != expected.Line {
+					t.Errorf("Error mismatch\nExpected: %+v\nActual: %+v", expected, *errs[i])
+				}
+
+				if expected.Message != "" && errs[i].Message != expected.Message {
+					t.Errorf("Message mismatch. Expected: %s. Actual: %s", expected.Message, errs[i].Message)
+				}
+			}
+		})
+	}
+
+	t.Run("Invalid YAML", func(t *testing.T) {
+		_, err := DecodeTemplate([]byte("name: nginx\n  image: nginx"))
+		if err == nil {
+			t.Fatal("Expected error to occur")
+		}
+
+		if _, ok := err.(TemplateErrors); ok {
+			t.Errorf("Expected syntax error. Actual: %s", err)
+		}
+	})
+}
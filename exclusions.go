@@ -0,0 +1,121 @@
+package injector
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annotationKeyMirrorPod is the annotation that the kubelet adds to the mirror pods of static pods.
+	annotationKeyMirrorPod = "kubernetes.io/config.mirror"
+
+	// labelKeyNamespaceExcluded is the label of the excluded namespaces, which the namespace selector of the webhooks skips. Namespaces aren't labelled with their name before Kubernetes 1.21, so the excluded namespaces are labelled explicitly.
+	labelKeyNamespaceExcluded = "sidecar.example.org/excluded"
+)
+
+// Exclusions define the pods that the webhook never mutates or validates, regardless of their sidecar injection annotation. They prevent the webhook from injecting the sidecar into system pods and its own pods, which could deadlock the restart of the webhook server when it's unavailable.
+type Exclusions struct {
+	// Namespaces is the list of namespaces whose pods are excluded.
+	Namespaces []string
+
+	// Labels excludes the pods that have any of these labels, such as the labels of the webhook server's own pods.
+	Labels map[string]string
+
+	// StaticPods excludes static pods and their mirror pods, which can't be mutated.
+	StaticPods bool
+}
+
+// DefaultExclusions returns the exclusions of the kube-system and kube-public namespaces, the pods labelled app=sidecar-injector, and static pods.
+func DefaultExclusions() *Exclusions {
+	return &Exclusions{
+		Namespaces: []string{metav1.NamespaceSystem, metav1.NamespacePublic},
+		Labels:     map[string]string{"app": "sidecar-injector"},
+		StaticPods: true,
+	}
+}
+
+// excluded returns the reason why pod is excluded. An empty string is returned if the pod isn't excluded. A nil Exclusions excludes nothing.
+func (e *Exclusions) excluded(pod *corev1.Pod) string {
+	if e == nil {
+		return ""
+	}
+
+	for _, namespace := range e.Namespaces {
+		if pod.Namespace == namespace {
+			return fmt.Sprintf("namespace %q is excluded", namespace)
+		}
+	}
+
+	labels := pod.ObjectMeta.GetLabels()
+	for _, key := range e.labelKeys() {
+		if value, exists := labels[key]; exists && value == e.Labels[key] {
+			return fmt.Sprintf("label %s=%s is excluded", key, value)
+		}
+	}
+
+	if e.StaticPods {
+		if _, exists := pod.ObjectMeta.GetAnnotations()[annotationKeyMirrorPod]; exists {
+			return "static pods are excluded"
+		}
+
+		if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "Node" {
+			return "static pods are excluded"
+		}
+	}
+
+	return ""
+}
+
+// namespaceSelector returns the namespace selector that excludes the namespaces of e, merged with selector. The excluded namespaces are selected by their sidecar.example.org/excluded=true label. Namespaces without the label are still excluded by the webhook server.
+func (e *Exclusions) namespaceSelector(selector *metav1.LabelSelector) *metav1.LabelSelector {
+	if e == nil || len(e.Namespaces) == 0 {
+		return selector
+	}
+
+	return withRequirement(selector, metav1.LabelSelectorRequirement{
+		Key:      labelKeyNamespaceExcluded,
+		Operator: metav1.LabelSelectorOpNotIn,
+		Values:   []string{"true"},
+	})
+}
+
+// objectSelector returns the object selector that excludes the labels of e, merged with selector. Static pods can't be excluded by label selectors.
+func (e *Exclusions) objectSelector(selector *metav1.LabelSelector) *metav1.LabelSelector {
+	if e == nil {
+		return selector
+	}
+
+	for _, key := range e.labelKeys() {
+		selector = withRequirement(selector, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   []string{e.Labels[key]},
+		})
+	}
+
+	return selector
+}
+
+func (e *Exclusions) labelKeys() []string {
+	keys := make([]string, 0, len(e.Labels))
+	for key := range e.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// withRequirement returns a copy of selector with the requirement appended to its match expressions.
+func withRequirement(selector *metav1.LabelSelector, requirement metav1.LabelSelectorRequirement) *metav1.LabelSelector {
+	merged := &metav1.LabelSelector{}
+	if selector != nil {
+		merged = selector.DeepCopy()
+	}
+	merged.MatchExpressions = append(merged.MatchExpressions, requirement)
+
+	return merged
+}
@@ -0,0 +1,75 @@
+package injector
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExclusions(t *testing.T) {
+	isController := true
+	var testCases = []struct {
+		name       string
+		exclusions *Exclusions
+		pod        *corev1.Pod
+		expected   bool
+	}{
+		{
+			name:       "pod in default namespace",
+			exclusions: DefaultExclusions(),
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "busybox"}},
+			expected:   false,
+		},
+		{
+			name:       "pod in kube-system namespace",
+			exclusions: DefaultExclusions(),
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "coredns"}},
+			expected:   true,
+		},
+		{
+			name:       "injector pod",
+			exclusions: DefaultExclusions(),
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", GenerateName: "sidecar-injector-", Labels: map[string]string{"app": "sidecar-injector"}}},
+			expected:   true,
+		},
+		{
+			name:       "pod with same label key",
+			exclusions: DefaultExclusions(),
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "busybox", Labels: map[string]string{"app": "busybox"}}},
+			expected:   false,
+		},
+		{
+			name:       "mirror pod",
+			exclusions: DefaultExclusions(),
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "etcd-node-0", Annotations: map[string]string{annotationKeyMirrorPod: "2f8c0d0e"}}},
+			expected:   true,
+		},
+		{
+			name:       "static pod",
+			exclusions: DefaultExclusions(),
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "etcd-node-0", OwnerReferences: []metav1.OwnerReference{{Kind: "Node", Name: "node-0", Controller: &isController}}}},
+			expected:   true,
+		},
+		{
+			name:       "static pod without exclusion",
+			exclusions: &Exclusions{},
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "etcd-node-0", Annotations: map[string]string{annotationKeyMirrorPod: "2f8c0d0e"}}},
+			expected:   false,
+		},
+		{
+			name:       "nil exclusions",
+			exclusions: nil,
+			pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "coredns"}},
+			expected:   false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if actual := testCase.exclusions.excluded(testCase.pod) != ""; actual != testCase.expected {
+				t.Errorf("Boolean mismatch. Expected: %t. Actual: %t", testCase.expected, actual)
+			}
+		})
+	}
+}
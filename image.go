@@ -0,0 +1,125 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const defaultRegistry = "docker.io"
+
+// imageRegistry returns the registry host of the image reference. Images without an explicit registry are pulled from Docker Hub.
+func imageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return defaultRegistry
+	}
+
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return defaultRegistry
+	}
+
+	return host
+}
+
+// registryAllowed returns true if the registry of image is found in registries. An empty list allows all registries.
+func registryAllowed(image string, registries []string) bool {
+	if len(registries) == 0 {
+		return true
+	}
+
+	registry := imageRegistry(image)
+	for _, allowed := range registries {
+		if registry == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImagePolicy defines the rules that the sidecar images must satisfy before they can be injected.
+type ImagePolicy struct {
+	// AllowedRegistries is the list of registries that sidecar images can be pulled from. An empty list allows all registries.
+	AllowedRegistries []string
+
+	// RequireDigest rejects sidecar images that aren't pinned to a digest, after the Digests mapping is applied.
+	RequireDigest bool
+
+	// Digests maps image references to their digest-pinned references e.g. "nginx:1.15" to "nginx@sha256:...". The image references are matched after they are normalized, so that "nginx:1.15" and "docker.io/library/nginx:1.15" refer to the same image.
+	Digests map[string]string
+}
+
+// LoadDigestMapping reads the JSON object in filename that maps image references to their digest-pinned references. The image references are normalized with normalizeImage.
+func LoadDigestMapping(filename string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping map[string]string
+	if err := json.Unmarshal(b, &mapping); err != nil {
+		return nil, fmt.Errorf("Failed to parse digest mapping file %s: %s", filename, err)
+	}
+
+	digests := make(map[string]string, len(mapping))
+	for image, pinned := range mapping {
+		normalized := normalizeImage(image)
+		if existing, exists := digests[normalized]; exists && existing != pinned {
+			return nil, fmt.Errorf("Failed to parse digest mapping file %s: image %q is mapped to both %q and %q", filename, normalized, existing, pinned)
+		}
+		digests[normalized] = pinned
+	}
+
+	return digests, nil
+}
+
+// apply rewrites image to its pinned digest, if one is defined, and verifies that the result satisfies the policy. An error explaining the violation is returned if it doesn't.
+func (p *ImagePolicy) apply(image string) (string, error) {
+	if p == nil {
+		return image, nil
+	}
+
+	if pinned, exists := p.Digests[image]; exists {
+		image = pinned
+	} else if pinned, exists := p.Digests[normalizeImage(image)]; exists {
+		image = pinned
+	}
+
+	if !registryAllowed(image, p.AllowedRegistries) {
+		return "", fmt.Errorf("Sidecar image %q violates the image policy: registry %q isn't allowed. Allowed registries: %s", image, imageRegistry(image), strings.Join(p.AllowedRegistries, ", "))
+	}
+
+	if p.RequireDigest && !strings.Contains(image, "@") {
+		return "", fmt.Errorf("Sidecar image %q violates the image policy: image must be pinned to a digest", image)
+	}
+
+	return image, nil
+}
+
+// normalizeImage returns the fully qualified form of the image reference, with the registry, the repository and the tag e.g. "nginx" is normalized to "docker.io/library/nginx:latest". The registry is resolved with imageRegistry, the same way the registry allow-list is checked.
+func normalizeImage(image string) string {
+	registry := imageRegistry(image)
+	repository := strings.TrimPrefix(image, registry+"/")
+	if registry == defaultRegistry && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+
+	return imageWithTag(registry + "/" + repository)
+}
+
+// imageWithTag returns image with the implicit 'latest' tag added, if it has neither a tag nor a digest.
+func imageWithTag(image string) string {
+	if strings.Contains(image, "@") {
+		return image
+	}
+
+	name := image[strings.LastIndex(image, "/")+1:]
+	if strings.Contains(name, ":") {
+		return image
+	}
+
+	return image + ":latest"
+}
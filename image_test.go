@@ -0,0 +1,112 @@
+package injector
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImagePolicy(t *testing.T) {
+	digests, err := LoadDigestMapping(filepath.Join("test", "data", "image-digests.json"))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var testCases = []struct {
+		name      string
+		policy    *ImagePolicy
+		image     string
+		expected  string
+		expectErr bool
+	}{
+		{name: "Nil Policy", policy: nil, image: "nginx", expected: "nginx"},
+		{name: "Allowed Registry", policy: &ImagePolicy{AllowedRegistries: []string{"docker.io"}}, image: "nginx:1.15", expected: "nginx:1.15"},
+		{name: "Disallowed Registry", policy: &ImagePolicy{AllowedRegistries: []string{"gcr.io"}}, image: "nginx:1.15", expectErr: true},
+		{name: "Pinned Untagged Image", policy: &ImagePolicy{Digests: digests}, image: "nginx", expected: digests["docker.io/library/nginx:latest"]},
+		{name: "Pinned Qualified Image", policy: &ImagePolicy{Digests: digests, RequireDigest: true}, image: "docker.io/library/nginx:latest", expected: digests["docker.io/library/nginx:latest"]},
+		{name: "Pinned Tagged Image", policy: &ImagePolicy{Digests: digests, RequireDigest: true}, image: "gcr.io/example/nginx:1.15", expected: digests["gcr.io/example/nginx:1.15"]},
+		{name: "Unpinned Image", policy: &ImagePolicy{Digests: digests, RequireDigest: true}, image: "nginx:1.14", expectErr: true},
+		{name: "Digest Image", policy: &ImagePolicy{RequireDigest: true}, image: digests["docker.io/library/nginx:latest"], expected: digests["docker.io/library/nginx:latest"]},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, err := testCase.policy.apply(testCase.image)
+			if testCase.expectErr {
+				if err == nil {
+					t.Error("Expected error didn't occur")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if actual != testCase.expected {
+				t.Errorf("Image mismatch. Expected: %s. Actual: %s", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLoadDigestMapping(t *testing.T) {
+	expected := map[string]string{
+		"docker.io/library/nginx:latest": "nginx@sha256:d85914d547a6c92faa39ce7058bd7529baacab7e0cd4255442b04577c4d1f424",
+		"gcr.io/example/nginx:1.15":      "gcr.io/example/nginx@sha256:9d9e558be64ecbcf6d05cb0a9d529c7d4d11be43346553170f61f01f2f0a9b8d",
+	}
+
+	actual, err := LoadDigestMapping(filepath.Join("test", "data", "image-digests.json"))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+	}
+}
+
+func TestImageRegistry(t *testing.T) {
+	var testCases = []struct {
+		image    string
+		expected string
+	}{
+		{image: "nginx", expected: "docker.io"},
+		{image: "library/nginx:1.15", expected: "docker.io"},
+		{image: "gcr.io/example/nginx", expected: "gcr.io"},
+		{image: "localhost/nginx", expected: "localhost"},
+		{image: "registry:5000/nginx", expected: "registry:5000"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.image, func(t *testing.T) {
+			if actual := imageRegistry(testCase.image); actual != testCase.expected {
+				t.Errorf("Registry mismatch. Expected: %s. Actual: %s", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	var testCases = []struct {
+		image    string
+		expected string
+	}{
+		{image: "nginx", expected: "docker.io/library/nginx:latest"},
+		{image: "nginx:1.14", expected: "docker.io/library/nginx:1.14"},
+		{image: "library/nginx:1.14", expected: "docker.io/library/nginx:1.14"},
+		{image: "docker.io/library/nginx:1.14", expected: "docker.io/library/nginx:1.14"},
+		{image: "example/nginx", expected: "docker.io/example/nginx:latest"},
+		{image: "gcr.io/example/nginx:1.15", expected: "gcr.io/example/nginx:1.15"},
+		{image: "registry:5000/nginx", expected: "registry:5000/nginx:latest"},
+		{image: "nginx@sha256:d85914d547a6c92faa39ce7058bd7529baacab7e0cd4255442b04577c4d1f424", expected: "docker.io/library/nginx@sha256:d85914d547a6c92faa39ce7058bd7529baacab7e0cd4255442b04577c4d1f424"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.image, func(t *testing.T) {
+			if actual := normalizeImage(testCase.image); actual != testCase.expected {
+				t.Errorf("Image mismatch. Expected: %s. Actual: %s", testCase.expected, actual)
+			}
+		})
+	}
+}
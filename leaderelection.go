@@ -0,0 +1,265 @@
+package injector
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// annotationKeyLeader is the annotation of the lock object that holds the leader election record. It's compatible with the configmap lock of client-go.
+const annotationKeyLeader = "control-plane.alpha.kubernetes.io/leader"
+
+// LeaderElector elects a leader among the webhook replicas, so that the background loops such as the rollout controller run on only one replica at a time. Admission requests are served by all replicas regardless of leadership. The leader election record is stored in the annotations of a configmap lock.
+type LeaderElector struct {
+	client kubernetes.Interface
+	logger *logrus.Logger
+	now    func() time.Time
+
+	// Namespace and Name identify the configmap lock.
+	Namespace string
+	Name      string
+
+	// Identity is the unique identity of this replica e.g. its pod name.
+	Identity string
+
+	// LeaseDuration is the duration that non-leader replicas wait before they attempt to acquire an unrenewed leadership.
+	LeaseDuration time.Duration
+
+	// RenewDeadline is the duration that the leader retries renewing its leadership before it gives up.
+	RenewDeadline time.Duration
+
+	// RetryPeriod is the duration between attempts to acquire or renew the leadership.
+	RetryPeriod time.Duration
+
+	mu        sync.RWMutex
+	leader    bool
+	holder    string
+	renewedAt time.Time
+
+	// observedRecord is the raw leader election record last read from the lock, and observedTime is the local time when it was first read. Leases expire relative to observedTime, rather than to the renew time of the record, so that the clock skew between the replicas doesn't matter.
+	observedRecord string
+	observedTime   time.Time
+}
+
+type leaderElectionRecord struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaderTransitions    int         `json:"leaderTransitions"`
+}
+
+// NewLeaderElector returns a new instance of LeaderElector that uses the configmap namespace/name as its lock.
+func NewLeaderElector(w *Webhook, namespace, name, identity string) *LeaderElector {
+	return &LeaderElector{
+		client:        w.Client,
+		logger:        w.logger,
+		now:           time.Now,
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      identity,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+// IsLeader returns true if this replica is the leader.
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader
+}
+
+// Leader returns the identity of the last observed leader.
+func (e *LeaderElector) Leader() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.holder
+}
+
+// Run competes for the leadership until stopCh is closed. Every time this replica becomes the leader, onStartedLeading is called in a new goroutine. The stop channel passed to onStartedLeading is closed when the leadership is lost.
+func (e *LeaderElector) Run(stopCh <-chan struct{}, onStartedLeading func(stopLeading <-chan struct{})) {
+	for {
+		if !e.acquire(stopCh) {
+			return
+		}
+
+		e.logger.Infof("Acquired leadership of %s/%s as %s", e.Namespace, e.Name, e.Identity)
+		stopLeading := make(chan struct{})
+		go onStartedLeading(stopLeading)
+
+		e.renew(stopCh)
+		close(stopLeading)
+		e.setLeader(false)
+		e.logger.Infof("Lost leadership of %s/%s", e.Namespace, e.Name)
+
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+	}
+}
+
+// acquire retries acquiring the leadership until it succeeds or stopCh is closed. It returns false if stopCh is closed.
+func (e *LeaderElector) acquire(stopCh <-chan struct{}) bool {
+	ticker := time.NewTicker(e.RetryPeriod)
+	defer ticker.Stop()
+
+	for {
+		if e.tryAcquireOrRenew() {
+			return true
+		}
+
+		select {
+		case <-stopCh:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew renews the leadership at every retry period, until the renewal fails for longer than the renew deadline, or stopCh is closed.
+func (e *LeaderElector) renew(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(e.RetryPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		if e.tryAcquireOrRenew() {
+			continue
+		}
+
+		e.mu.RLock()
+		expired := e.now().Sub(e.renewedAt) > e.RenewDeadline
+		e.mu.RUnlock()
+		if expired {
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew attempts to acquire the leadership, or renew it if this replica is already the leader. It returns true on success.
+func (e *LeaderElector) tryAcquireOrRenew() bool {
+	now := metav1.NewTime(e.now())
+	record := leaderElectionRecord{
+		HolderIdentity:       e.Identity,
+		LeaseDurationSeconds: int(e.LeaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	configMaps := e.client.CoreV1().ConfigMaps(e.Namespace)
+	lock, err := configMaps.Get(e.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			e.logger.Errorf("Failed to get leader election lock %s/%s. Reason: %s", e.Namespace, e.Name, err)
+			return false
+		}
+
+		lock = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: e.Namespace, Name: e.Name}}
+		if err := setLeaderElectionRecord(lock, record); err != nil {
+			return false
+		}
+
+		if _, err := configMaps.Create(lock); err != nil {
+			e.logger.Errorf("Failed to create leader election lock %s/%s. Reason: %s", e.Namespace, e.Name, err)
+			return false
+		}
+
+		e.observeRecord(lock.Annotations[annotationKeyLeader], now.Time)
+		e.observe(record, true)
+		return true
+	}
+
+	var observed leaderElectionRecord
+	raw, exists := lock.GetAnnotations()[annotationKeyLeader]
+	if exists {
+		if err := json.Unmarshal([]byte(raw), &observed); err != nil {
+			e.logger.Errorf("Failed to parse leader election record of %s/%s. Reason: %s", e.Namespace, e.Name, err)
+		}
+	}
+
+	leaseDuration := time.Duration(observed.LeaseDurationSeconds) * time.Second
+	held := observed.HolderIdentity != "" && e.observeRecord(raw, now.Time).Add(leaseDuration).After(now.Time)
+	if held && observed.HolderIdentity != e.Identity {
+		e.observe(observed, false)
+		return false
+	}
+
+	if observed.HolderIdentity == e.Identity {
+		record.AcquireTime = observed.AcquireTime
+		record.LeaderTransitions = observed.LeaderTransitions
+	} else {
+		record.LeaderTransitions = observed.LeaderTransitions + 1
+	}
+
+	if err := setLeaderElectionRecord(lock, record); err != nil {
+		return false
+	}
+
+	// the update fails with a conflict if another replica updated the lock since it was read
+	if _, err := configMaps.Update(lock); err != nil {
+		e.logger.Errorf("Failed to update leader election lock %s/%s. Reason: %s", e.Namespace, e.Name, err)
+		return false
+	}
+
+	e.observeRecord(lock.Annotations[annotationKeyLeader], now.Time)
+	e.observe(record, true)
+	return true
+}
+
+func (e *LeaderElector) observe(record leaderElectionRecord, leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.holder = record.HolderIdentity
+	e.leader = leader
+	if leader {
+		e.renewedAt = record.RenewTime.Time
+	}
+}
+
+// observeRecord records the raw leader election record read from the lock at the local time now, and returns the local time when the record was first read.
+func (e *LeaderElector) observeRecord(raw string, now time.Time) time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if raw != e.observedRecord || e.observedTime.IsZero() {
+		e.observedRecord = raw
+		e.observedTime = now
+	}
+
+	return e.observedTime
+}
+
+func (e *LeaderElector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = leader
+}
+
+func setLeaderElectionRecord(lock *corev1.ConfigMap, record leaderElectionRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if lock.Annotations == nil {
+		lock.Annotations = map[string]string{}
+	}
+	lock.Annotations[annotationKeyLeader] = string(b)
+	return nil
+}
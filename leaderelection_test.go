@@ -0,0 +1,101 @@
+package injector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderElection(t *testing.T) {
+	w, err := initWebhookWithConfigMap()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var (
+		now   = time.Date(2018, time.August, 22, 19, 15, 0, 0, time.UTC)
+		clock = func() time.Time { return now }
+	)
+
+	replica0 := NewLeaderElector(w, "default", "sidecar-injector-leader", "replica-0")
+	replica0.now = clock
+	replica1 := NewLeaderElector(w, "default", "sidecar-injector-leader", "replica-1")
+	replica1.now = clock
+
+	if !replica0.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-0 to acquire the leadership")
+	}
+
+	if replica1.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-1 to not acquire the leadership held by replica-0")
+	}
+
+	if !replica0.IsLeader() || replica1.IsLeader() {
+		t.Errorf("Leadership mismatch. Expected replica-0 to be the only leader")
+	}
+
+	if actual := replica1.Leader(); actual != "replica-0" {
+		t.Errorf("Observed leader mismatch. Expected: replica-0. Actual: %s", actual)
+	}
+
+	// renewals extend the lease of the leader
+	now = now.Add(10 * time.Second)
+	if !replica0.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-0 to renew the leadership")
+	}
+
+	now = now.Add(10 * time.Second)
+	if replica1.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-1 to not acquire the renewed leadership")
+	}
+
+	// unrenewed leaderships can be acquired after the lease expires
+	now = now.Add(replica0.LeaseDuration)
+	if !replica1.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-1 to acquire the expired leadership")
+	}
+
+	if actual := replica1.Leader(); actual != "replica-1" {
+		t.Errorf("Observed leader mismatch. Expected: replica-1. Actual: %s", actual)
+	}
+}
+
+func TestLeaderElectionClockSkew(t *testing.T) {
+	w, err := initWebhookWithConfigMap()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var (
+		now    = time.Date(2018, time.August, 22, 19, 15, 0, 0, time.UTC)
+		skewed = now.Add(time.Hour)
+	)
+
+	replica0 := NewLeaderElector(w, "default", "sidecar-injector-leader", "replica-0")
+	replica0.now = func() time.Time { return now }
+	replica1 := NewLeaderElector(w, "default", "sidecar-injector-leader", "replica-1")
+	replica1.now = func() time.Time { return skewed }
+
+	if !replica0.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-0 to acquire the leadership")
+	}
+
+	// the renew time of the record is an hour behind the clock of replica-1
+	if replica1.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-1 to not acquire the leadership held by replica-0")
+	}
+
+	// the lease expires relative to when replica-1 last saw the record change
+	now, skewed = now.Add(10*time.Second), skewed.Add(10*time.Second)
+	if !replica0.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-0 to renew the leadership")
+	}
+
+	if replica1.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-1 to not acquire the renewed leadership")
+	}
+
+	skewed = skewed.Add(replica1.LeaseDuration + time.Second)
+	if !replica1.tryAcquireOrRenew() {
+		t.Fatal("Expected replica-1 to acquire the expired leadership")
+	}
+}
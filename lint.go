@@ -0,0 +1,170 @@
+package injector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The severities of the lint findings. Errors are problems that break the injected pods, while warnings are risky or discouraged settings.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// The rules of the lint findings.
+const (
+	RuleSchema        = "schema"
+	RuleResources     = "resources"
+	RuleImageTag      = "image-tag"
+	RulePrivileged    = "privileged"
+	RulePortCollision = "port-collision"
+	RuleReservedName  = "reserved-name"
+)
+
+var (
+	// commonAppPorts are the container ports that are commonly used by applications, and likely to collide with the ports of the sidecar.
+	commonAppPorts = map[int32]bool{80: true, 443: true, 3000: true, 5000: true, 8000: true, 8080: true, 8443: true, 9000: true}
+
+	// reservedContainerNames are the names of the containers that are injected by other well-known injectors.
+	reservedContainerNames = map[string]bool{
+		"istio-proxy":      true,
+		"istio-init":       true,
+		"linkerd-proxy":    true,
+		"linkerd-init":     true,
+		"vault-agent":      true,
+		"vault-agent-init": true,
+		"cloudsql-proxy":   true,
+	}
+
+	// privilegedCapabilities are the capabilities that grant privileged access to the node.
+	privilegedCapabilities = map[corev1.Capability]bool{"ALL": true, "SYS_ADMIN": true, "NET_ADMIN": true, "SYS_PTRACE": true, "SYS_MODULE": true}
+)
+
+// LintFinding is a problem of a sidecar template found by LintTemplate. Line is the line of the field in the template source, or 0 if the finding doesn't have a field or the field can't be located.
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Field    string `json:"field,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+// LintTemplate decodes the YAML or JSON sidecar template in data with the same decoder as the webhook, and returns its findings, sorted by line. If the template can't be decoded, only the schema errors are returned.
+func LintTemplate(data []byte) []*LintFinding {
+	template, err := DecodeTemplate(data)
+	if err != nil {
+		errs, ok := err.(TemplateErrors)
+		if !ok {
+			return []*LintFinding{{Rule: RuleSchema, Severity: SeverityError, Message: err.Error()}}
+		}
+
+		findings := []*LintFinding{}
+		for _, err := range errs {
+			findings = append(findings, &LintFinding{Rule: RuleSchema, Severity: SeverityError, Field: err.Field, Line: err.Line, Message: err.Message})
+		}
+		return findings
+	}
+
+	findings := []*LintFinding{}
+	add := func(rule, severity string, path *field.Path, format string, args ...interface{}) {
+		findings = append(findings, &LintFinding{
+			Rule:     rule,
+			Severity: severity,
+			Field:    path.String(),
+			Line:     locate(data, path.String()),
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if template.Overlay == nil {
+		lintContainer(add, &template.Container, nil, template.InheritResources, template.DefaultResources)
+	} else {
+		for _, list := range []struct {
+			name       string
+			containers []corev1.Container
+		}{
+			{name: "initContainers", containers: template.Overlay.InitContainers},
+			{name: "containers", containers: template.Overlay.Containers},
+		} {
+			for i := range list.containers {
+				lintContainer(add, &list.containers[i], field.NewPath("overlay").Child(list.name).Index(i), false, nil)
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Line < findings[j].Line
+	})
+
+	return findings
+}
+
+// lintContainer adds the findings of container, whose field path is path. Containers without an image, such as the overlay containers that are merged into the containers of the pod, aren't checked for missing resources and image tags.
+func lintContainer(add func(rule, severity string, path *field.Path, format string, args ...interface{}), container *corev1.Container, path *field.Path, inheritResources bool, defaults *corev1.ResourceRequirements) {
+	if container.Image != "" && !inheritResources {
+		// resources that are set by the defaults of the template aren't missing
+		if defaults == nil {
+			defaults = &corev1.ResourceRequirements{}
+		}
+
+		resources := path.Child("resources")
+		for _, list := range []struct {
+			name      string
+			resources corev1.ResourceList
+		}{
+			{name: "requests", resources: fillResourceList(container.Resources.Requests.DeepCopy(), defaults.Requests)},
+			{name: "limits", resources: fillResourceList(container.Resources.Limits.DeepCopy(), defaults.Limits)},
+		} {
+			for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+				if _, exists := list.resources[name]; !exists {
+					add(RuleResources, SeverityWarning, resources.Child(list.name), "%s %s aren't set", name, list.name)
+				}
+			}
+		}
+	}
+
+	if image := container.Image; image != "" && !strings.Contains(image, "@") && strings.HasSuffix(imageWithTag(image), ":latest") {
+		add(RuleImageTag, SeverityWarning, path.Child("image"), "image %s isn't pinned to a tag other than latest, or to a digest", image)
+	}
+
+	if sc := container.SecurityContext; sc != nil {
+		securityContext := path.Child("securityContext")
+		if sc.Privileged != nil && *sc.Privileged {
+			add(RulePrivileged, SeverityError, securityContext.Child("privileged"), "container is privileged")
+		}
+
+		if sc.AllowPrivilegeEscalation != nil && *sc.AllowPrivilegeEscalation {
+			add(RulePrivileged, SeverityWarning, securityContext.Child("allowPrivilegeEscalation"), "privilege escalation is allowed")
+		}
+
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			add(RulePrivileged, SeverityWarning, securityContext.Child("runAsUser"), "container runs as root")
+		}
+
+		if sc.Capabilities != nil {
+			for i, capability := range sc.Capabilities.Add {
+				if privilegedCapabilities[capability] {
+					add(RulePrivileged, SeverityError, securityContext.Child("capabilities", "add").Index(i), "capability %s grants privileged access to the node", capability)
+				}
+			}
+		}
+	}
+
+	for i, port := range container.Ports {
+		if commonAppPorts[port.ContainerPort] {
+			add(RulePortCollision, SeverityWarning, path.Child("ports").Index(i).Child("containerPort"), "port %d is commonly used by applications", port.ContainerPort)
+		}
+
+		if port.HostPort != 0 {
+			add(RulePortCollision, SeverityWarning, path.Child("ports").Index(i).Child("hostPort"), "host port %d collides across the pods on the same node", port.HostPort)
+		}
+	}
+
+	if reservedContainerNames[container.Name] {
+		add(RuleReservedName, SeverityError, path.Child("name"), "container name %s is reserved by another injector", container.Name)
+	}
+}
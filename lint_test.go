@@ -0,0 +1,106 @@
+package injector
+
+import "testing"
+
+func TestLintTemplate(t *testing.T) {
+	type finding struct {
+		rule     string
+		severity string
+		field    string
+		line     int
+	}
+
+	var testCases = []struct {
+		name     string
+		data     string
+		expected []finding
+	}{
+		{
+			name: "Clean",
+			data: `
+name: proxy
+image: envoyproxy/envoy:v1.8.0
+ports:
+- name: admin
+  containerPort: 15000
+resources:
+  requests:
+    cpu: 100m
+    memory: 64Mi
+  limits:
+    cpu: 200m
+    memory: 128Mi
+`,
+		},
+		{
+			name: "Inherited resources",
+			data: `{"name": "proxy", "image": "envoyproxy/envoy@sha256:0123456789abcdef", "inheritResources": true}`,
+		},
+		{
+			name: "Schema errors",
+			data: `
+name: proxy
+image: envoyproxy/envoy:v1.8.0
+ports:
+- containerport: 15000
+`,
+			expected: []finding{
+				{rule: RuleSchema, severity: SeverityError, field: "ports[0].containerport", line: 5},
+			},
+		},
+		{
+			name: "Risky settings",
+			data: `
+name: istio-proxy
+image: nginx
+ports:
+- name: http
+  containerPort: 8080
+  hostPort: 18080
+securityContext:
+  privileged: true
+  runAsUser: 0
+  capabilities:
+    add:
+    - NET_BIND_SERVICE
+    - NET_ADMIN
+resources:
+  limits:
+    cpu: 100m
+    memory: 64Mi
+`,
+			expected: []finding{
+				{rule: RuleResources, severity: SeverityWarning, field: "resources.requests", line: 0},
+				{rule: RuleResources, severity: SeverityWarning, field: "resources.requests", line: 0},
+				{rule: RuleReservedName, severity: SeverityError, field: "name", line: 2},
+				{rule: RuleImageTag, severity: SeverityWarning, field: "image", line: 3},
+				{rule: RulePortCollision, severity: SeverityWarning, field: "ports[0].containerPort", line: 6},
+				{rule: RulePortCollision, severity: SeverityWarning, field: "ports[0].hostPort", line: 7},
+				{rule: RulePrivileged, severity: SeverityError, field: "securityContext.privileged", line: 9},
+				{rule: RulePrivileged, severity: SeverityWarning, field: "securityContext.runAsUser", line: 10},
+				{rule: RulePrivileged, severity: SeverityError, field: "securityContext.capabilities.add[1]", line: 14},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			findings := LintTemplate([]byte(testCase.data))
+			var actual []finding
+			for _, f := range findings {
+				actual = append(actual, finding{rule: f.Rule, severity: f.Severity, field: f.Field, line: f.Line})
+			}
+
+			expected := testCase.expected
+			if len(actual) != len(expected) {
+				t.Fatalf("Findings mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+			}
+
+			for i := range expected {
+				if actual[i] != expected[i] {
+					t.Errorf("Finding mismatch\nExpected: %+v\nActual: %+v", expected[i], actual[i])
+				}
+			}
+		})
+	}
+}
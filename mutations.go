@@ -0,0 +1,170 @@
+package injector
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	patchPathLabel                 = "/metadata/labels"
+	patchPathDNSConfig             = "/spec/dnsConfig"
+	patchPathShareProcessNamespace = "/spec/shareProcessNamespace"
+	patchPathHostAliases           = "/spec/hostAliases"
+	patchPathImagePullSecrets      = "/spec/imagePullSecrets"
+	patchPathServiceAccountName    = "/spec/serviceAccountName"
+	patchPathServiceAccount        = "/spec/serviceAccount"
+
+	defaultServiceAccount = "default"
+)
+
+// PodMutations are the pod-level settings of the sidecar template. They are merged into the pod, without overwriting its existing values.
+type PodMutations struct {
+	// Labels are added to the pod, unless it already has labels with the same keys.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are added to the pod, unless it already has annotations with the same keys.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// DNSConfig is merged into the DNS config of the pod. The nameservers, searches and options that the pod doesn't have are appended.
+	DNSConfig *corev1.PodDNSConfig `json:"dnsConfig,omitempty"`
+
+	// ShareProcessNamespace is set, unless the pod sets it.
+	ShareProcessNamespace *bool `json:"shareProcessNamespace,omitempty"`
+
+	// HostAliases are merged into the host aliases of the pod by IP. The hostnames that the pod doesn't have are appended.
+	HostAliases []corev1.HostAlias `json:"hostAliases,omitempty"`
+
+	// ImagePullSecrets that the pod doesn't have are appended.
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// ServiceAccountName replaces the service account of the pod templates that use the default service account. Pods aren't changed, as the API server mounts the token of their service account before the webhook mutates them.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// addPodMutationsPatch merges the pod-level settings of mutations into the pod. Values that are already set by the pod are kept.
+func (p *PodPatch) addPodMutationsPatch(mutations *PodMutations) {
+	if mutations == nil {
+		return
+	}
+
+	var (
+		pod  = p.original
+		spec = &pod.Spec
+	)
+
+	p.addMapPatch(patchPathLabel, pod.ObjectMeta.GetLabels(), missingKeys(pod.ObjectMeta.GetLabels(), mutations.Labels), false)
+	p.addAnnotationsPatch(missingKeys(pod.ObjectMeta.GetAnnotations(), mutations.Annotations))
+
+	if dnsConfig := mutations.DNSConfig; dnsConfig != nil {
+		if spec.DNSConfig == nil {
+			p.patchOps = append(p.patchOps, &patchOp{Op: "add", Path: p.prefix + patchPathDNSConfig, Value: dnsConfig})
+		} else {
+			p.addListPatch(patchPathDNSConfig+"/nameservers", len(spec.DNSConfig.Nameservers), missingStrings(spec.DNSConfig.Nameservers, dnsConfig.Nameservers))
+			p.addListPatch(patchPathDNSConfig+"/searches", len(spec.DNSConfig.Searches), missingStrings(spec.DNSConfig.Searches, dnsConfig.Searches))
+
+			options := []interface{}{}
+			for _, option := range dnsConfig.Options {
+				if !hasDNSOption(spec.DNSConfig.Options, option.Name) {
+					options = append(options, option)
+				}
+			}
+			p.addListPatch(patchPathDNSConfig+"/options", len(spec.DNSConfig.Options), options)
+		}
+	}
+
+	if mutations.ShareProcessNamespace != nil && spec.ShareProcessNamespace == nil {
+		p.patchOps = append(p.patchOps, &patchOp{Op: "add", Path: p.prefix + patchPathShareProcessNamespace, Value: *mutations.ShareProcessNamespace})
+	}
+
+	aliases := []interface{}{}
+	for _, alias := range mutations.HostAliases {
+		index := hostAliasIndex(spec.HostAliases, alias.IP)
+		if index == -1 {
+			aliases = append(aliases, alias)
+			continue
+		}
+
+		existing := spec.HostAliases[index].Hostnames
+		p.addListPatch(fmt.Sprintf("%s/%d/hostnames", patchPathHostAliases, index), len(existing), missingStrings(existing, alias.Hostnames))
+	}
+	p.addListPatch(patchPathHostAliases, len(spec.HostAliases), aliases)
+
+	secrets := []interface{}{}
+	for _, secret := range mutations.ImagePullSecrets {
+		if !hasImagePullSecret(spec.ImagePullSecrets, secret.Name) {
+			secrets = append(secrets, secret)
+		}
+	}
+	p.addListPatch(patchPathImagePullSecrets, len(spec.ImagePullSecrets), secrets)
+
+	// the service accounts chosen by the users are never replaced, and the token of the default service account is already mounted into pods
+	if name := mutations.ServiceAccountName; name != "" && p.prefix != "" && (spec.ServiceAccountName == "" || spec.ServiceAccountName == defaultServiceAccount) && spec.ServiceAccountName != name {
+		p.patchOps = append(p.patchOps, &patchOp{Op: "add", Path: p.prefix + patchPathServiceAccountName, Value: name})
+		if spec.DeprecatedServiceAccount != "" {
+			p.patchOps = append(p.patchOps, &patchOp{Op: "add", Path: p.prefix + patchPathServiceAccount, Value: name})
+		}
+	}
+}
+
+// missingKeys returns the entries of values whose keys aren't in existing.
+func missingKeys(existing, values map[string]string) map[string]string {
+	missing := map[string]string{}
+	for key, value := range values {
+		if _, exists := existing[key]; !exists {
+			missing[key] = value
+		}
+	}
+
+	return missing
+}
+
+// missingStrings returns the values that aren't in existing.
+func missingStrings(existing, values []string) []interface{} {
+	missing := []interface{}{}
+	for _, value := range values {
+		found := false
+		for _, s := range existing {
+			if s == value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missing = append(missing, value)
+		}
+	}
+
+	return missing
+}
+
+func hasDNSOption(options []corev1.PodDNSConfigOption, name string) bool {
+	for _, option := range options {
+		if option.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hostAliasIndex(aliases []corev1.HostAlias, ip string) int {
+	for i, alias := range aliases {
+		if alias.IP == ip {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func hasImagePullSecret(secrets []corev1.LocalObjectReference, name string) bool {
+	for _, secret := range secrets {
+		if secret.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
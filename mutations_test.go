@@ -0,0 +1,183 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodMutationsPatch(t *testing.T) {
+	template, err := DecodeTemplate([]byte(`
+name: nginx
+image: nginx
+pod:
+  labels:
+    mesh: enabled
+    app: proxy
+  annotations:
+    prometheus.io/scrape: "true"
+  dnsConfig:
+    nameservers:
+    - 10.0.0.10
+    options:
+    - name: ndots
+      value: "2"
+  shareProcessNamespace: true
+  hostAliases:
+  - ip: 127.0.0.1
+    hostnames:
+    - proxy.local
+  imagePullSecrets:
+  - name: registry
+  serviceAccountName: proxy
+`))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	shareProcessNamespace := false
+	var testCases = []struct {
+		name     string
+		pod      *corev1.Pod
+		expected []*patchOp
+	}{
+		{
+			name: "Pod without settings",
+			pod:  &corev1.Pod{},
+			expected: []*patchOp{
+				{Op: "add", Path: "/metadata/labels", Value: map[string]string{"app": "proxy", "mesh": "enabled"}},
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]string{"prometheus.io/scrape": "true"}},
+				{Op: "add", Path: "/spec/dnsConfig", Value: template.Pod.DNSConfig},
+				{Op: "add", Path: "/spec/shareProcessNamespace", Value: true},
+				{Op: "add", Path: "/spec/hostAliases", Value: template.Pod.HostAliases},
+				{Op: "add", Path: "/spec/imagePullSecrets", Value: template.Pod.ImagePullSecrets},
+			},
+		},
+		{
+			name: "Pod with settings",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"app": "web"},
+					Annotations: map[string]string{"prometheus.io/scrape": "false"},
+				},
+				Spec: corev1.PodSpec{
+					DNSConfig: &corev1.PodDNSConfig{
+						Nameservers: []string{"10.0.0.10"},
+						Searches:    []string{"svc.cluster.local"},
+					},
+					ShareProcessNamespace:    &shareProcessNamespace,
+					HostAliases:              []corev1.HostAlias{{IP: "10.1.1.1", Hostnames: []string{"db"}}, {IP: "127.0.0.1", Hostnames: []string{"app.local"}}},
+					ImagePullSecrets:         []corev1.LocalObjectReference{{Name: "registry"}},
+					ServiceAccountName:       "default",
+					DeprecatedServiceAccount: "default",
+				},
+			},
+			expected: []*patchOp{
+				{Op: "add", Path: "/metadata/labels/mesh", Value: "enabled"},
+				{Op: "add", Path: "/spec/dnsConfig/options", Value: template.Pod.DNSConfig.Options},
+				{Op: "add", Path: "/spec/hostAliases/1/hostnames/-", Value: "proxy.local"},
+			},
+		},
+		{
+			name: "Pod with service account",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"app": "web", "mesh": "disabled"},
+					Annotations: map[string]string{"prometheus.io/scrape": "false"},
+				},
+				Spec: corev1.PodSpec{
+					DNSConfig: &corev1.PodDNSConfig{
+						Nameservers: []string{"10.0.0.11"},
+						Options:     []corev1.PodDNSConfigOption{{Name: "ndots"}},
+					},
+					ShareProcessNamespace: &shareProcessNamespace,
+					HostAliases:           []corev1.HostAlias{{IP: "127.0.0.1", Hostnames: []string{"proxy.local"}}},
+					ImagePullSecrets:      []corev1.LocalObjectReference{{Name: "app"}},
+					ServiceAccountName:    "web",
+				},
+			},
+			expected: []*patchOp{
+				{Op: "add", Path: "/spec/dnsConfig/nameservers/-", Value: "10.0.0.10"},
+				{Op: "add", Path: "/spec/imagePullSecrets/-", Value: template.Pod.ImagePullSecrets[0]},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			podPatch := NewPodPatch(testCase.pod)
+			podPatch.addPodMutationsPatch(template.Pod)
+
+			expected, err := json.Marshal(testCase.expected)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := json.Marshal(podPatch.patchOps)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if string(actual) != string(expected) {
+				t.Errorf("Content mismatch\nExpected: %s\nActual: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestServiceAccountMutation(t *testing.T) {
+	mutations := &PodMutations{ServiceAccountName: "proxy"}
+
+	// the pod has the token volume of the default service account, mounted by the API server
+	pod, err := test.FixturePod(".", "pod-with-sidecar.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if len(pod.Spec.Volumes) == 0 || pod.Spec.Volumes[0].Secret == nil {
+		t.Fatalf("Expected the pod to have a service account token volume. Actual: %+v", pod.Spec.Volumes)
+	}
+
+	var testCases = []struct {
+		name     string
+		podPatch *PodPatch
+		expected []*patchOp
+	}{
+		{
+			name:     "Pod",
+			podPatch: NewPodPatch(pod),
+			expected: []*patchOp{},
+		},
+		{
+			name:     "Pod Template",
+			podPatch: NewPodTemplatePatch(&corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}, patchPrefixPodTemplate),
+			expected: []*patchOp{
+				{Op: "add", Path: "/spec/template/spec/serviceAccountName", Value: "proxy"},
+				{Op: "add", Path: "/spec/template/spec/serviceAccount", Value: "proxy"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.podPatch.addPodMutationsPatch(mutations)
+
+			expected, err := json.Marshal(testCase.expected)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := json.Marshal(testCase.podPatch.patchOps)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if string(actual) != string(expected) {
+				t.Errorf("Content mismatch\nExpected: %s\nActual: %s", expected, actual)
+			}
+		})
+	}
+}
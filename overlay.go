@@ -0,0 +1,386 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// annotationKeyOverlayItems is the annotation of the pod templates that records the names of the items of the injected overlay, as the overlay hash only covers these items.
+const annotationKeyOverlayItems = "sidecar.example.org/overlay-items"
+
+// overlayItems are the names of the containers, init containers and volumes of an overlay.
+type overlayItems struct {
+	InitContainers []string `json:"initContainers,omitempty"`
+	Containers     []string `json:"containers,omitempty"`
+	Volumes        []string `json:"volumes,omitempty"`
+}
+
+// newOverlayItems returns the names of the items of overlay.
+func newOverlayItems(overlay *corev1.PodSpec) *overlayItems {
+	items := &overlayItems{}
+	for _, container := range overlay.InitContainers {
+		items.InitContainers = append(items.InitContainers, container.Name)
+	}
+	for _, container := range overlay.Containers {
+		items.Containers = append(items.Containers, container.Name)
+	}
+	for _, volume := range overlay.Volumes {
+		items.Volumes = append(items.Volumes, volume.Name)
+	}
+
+	return items
+}
+
+// parseOverlayItems returns the overlay items recorded in the annotations of a pod template, or nil if they aren't recorded.
+func parseOverlayItems(annotations map[string]string) *overlayItems {
+	value, exists := annotations[annotationKeyOverlayItems]
+	if !exists {
+		return nil
+	}
+
+	items := &overlayItems{}
+	if err := json.Unmarshal([]byte(value), items); err != nil {
+		return nil
+	}
+
+	return items
+}
+
+func (i *overlayItems) String() string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+// overlayPatch returns the admission response with the patch that merges the overlay of template into the pod of podPatch. The images of the overlay containers are subject to the image policy.
+func (w *Webhook) overlayPatch(ctx context.Context, uid types.UID, podPatch *PodPatch, template *SidecarTemplate, version string) (*admissionv1beta1.AdmissionResponse, error) {
+	_, span := w.Tracer.Start(ctx, "patch")
+	defer span.Finish()
+
+	overlay, err := w.overlay(template)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	merged, err := podPatch.addOverlayPatch(overlay)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("Failed to merge the sidecar overlay: %s", err)
+	}
+
+	// the hash of pod templates tells the upgrades of the overlay apart from the user edits made since the injection
+	if podPatch.prefix == "" {
+		podPatch.addAnnotationPatch(version, "")
+	} else {
+		items := newOverlayItems(overlay)
+		hash, err := overlayHash(merged, items)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		podPatch.addAnnotationPatch(version, hash)
+		podPatch.addAnnotationsPatch(map[string]string{annotationKeyOverlayItems: items.String()})
+	}
+	podPatch.addPodMutationsPatch(template.Pod)
+	span.SetAttribute("patch.operations", len(podPatch.patchOps))
+
+	return patchResponse(uid, podPatch)
+}
+
+// overlay returns the overlay of template, with the images of its containers subject to the image policy.
+func (w *Webhook) overlay(template *SidecarTemplate) (*corev1.PodSpec, error) {
+	overlay := template.Overlay.DeepCopy()
+	for _, containers := range [][]corev1.Container{overlay.InitContainers, overlay.Containers} {
+		for i := range containers {
+			if containers[i].Image == "" {
+				continue
+			}
+
+			image, err := w.ImagePolicy.apply(containers[i].Image)
+			if err != nil {
+				return nil, err
+			}
+			containers[i].Image = image
+		}
+	}
+
+	return overlay, nil
+}
+
+// overlayHash returns the hash of the items of spec that the overlay with items was merged into, without the fields that the API server defaults. The other containers and volumes of spec aren't hashed, so that they can be edited without blocking the upgrades of the overlay. Items that spec doesn't have are skipped.
+func overlayHash(spec *corev1.PodSpec, items *overlayItems) (string, error) {
+	hashed := &corev1.PodSpec{}
+	for _, name := range items.InitContainers {
+		if i := containerIndex(spec.InitContainers, name); i != -1 {
+			hashed.InitContainers = append(hashed.InitContainers, *withoutDefaults(&spec.InitContainers[i]))
+		}
+	}
+
+	for _, name := range items.Containers {
+		if i := containerIndex(spec.Containers, name); i != -1 {
+			hashed.Containers = append(hashed.Containers, *withoutDefaults(&spec.Containers[i]))
+		}
+	}
+
+	for _, name := range items.Volumes {
+		for _, volume := range spec.Volumes {
+			if volume.Name != name {
+				continue
+			}
+
+			volume = *volume.DeepCopy()
+			source := &volume.VolumeSource
+			switch {
+			case source.Secret != nil:
+				source.Secret.DefaultMode = nil
+			case source.ConfigMap != nil:
+				source.ConfigMap.DefaultMode = nil
+			case source.DownwardAPI != nil:
+				source.DownwardAPI.DefaultMode = nil
+			case source.Projected != nil:
+				source.Projected.DefaultMode = nil
+			case source.HostPath != nil && source.HostPath.Type != nil && *source.HostPath.Type == corev1.HostPathUnset:
+				source.HostPath.Type = nil
+			}
+			hashed.Volumes = append(hashed.Volumes, volume)
+		}
+	}
+
+	return hashJSON(hashed)
+}
+
+// overlayViolation returns the reason why pod doesn't have the containers of overlay. An empty string is returned if it has all of them.
+func overlayViolation(pod *corev1.Pod, overlay *corev1.PodSpec) string {
+	for _, container := range overlay.InitContainers {
+		if containerIndex(pod.Spec.InitContainers, container.Name) == -1 {
+			return fmt.Sprintf("overlay init container %q is missing", container.Name)
+		}
+	}
+
+	for _, container := range overlay.Containers {
+		if containerIndex(pod.Spec.Containers, container.Name) == -1 {
+			return fmt.Sprintf("overlay container %q is missing", container.Name)
+		}
+	}
+
+	return ""
+}
+
+// addOverlayPatch merges overlay into the pod spec with the strategic merge patch semantics of the pod spec, and adds the operations that turn the original pod into the merged pod. The merged pod spec is returned.
+func (p *PodPatch) addOverlayPatch(overlay *corev1.PodSpec) (*corev1.PodSpec, error) {
+	original, err := p.originalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, err
+	}
+
+	// null values of the strategic merge patch delete the fields of the pod, such as the containers list of an overlay that only has volumes
+	patch, err := json.Marshal(map[string]interface{}{"spec": removeNulls(spec)})
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := strategicpatch.StrategicMergePatch(original, patch, corev1.Pod{})
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after interface{}
+	if err := json.Unmarshal(original, &before); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(merged, &after); err != nil {
+		return nil, err
+	}
+
+	var mergedPod corev1.Pod
+	if err := json.Unmarshal(merged, &mergedPod); err != nil {
+		return nil, err
+	}
+
+	p.patchOps = append(p.patchOps, diffJSON(p.prefix, before, after)...)
+	return &mergedPod.Spec, nil
+}
+
+// originalJSON returns the JSON of the original pod, or of the original pod template. The raw JSON of the admission request is preferred, as the paths of the patch must exist in the object of the API server.
+func (p *PodPatch) originalJSON() ([]byte, error) {
+	if p.raw != nil {
+		return p.raw, nil
+	}
+
+	return json.Marshal(&corev1.PodTemplateSpec{ObjectMeta: p.original.ObjectMeta, Spec: p.original.Spec})
+}
+
+// rawTemplate returns the raw JSON of the pod template at prefix of the workload controller in raw.
+func rawTemplate(raw []byte, prefix string) ([]byte, error) {
+	for _, key := range strings.Split(strings.TrimPrefix(prefix, "/"), "/") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, err
+		}
+
+		value, exists := object[key]
+		if !exists {
+			return nil, fmt.Errorf("Pod template not found at %s", prefix)
+		}
+		raw = value
+	}
+
+	return raw, nil
+}
+
+// removeNulls deletes the null fields of the objects in value.
+func removeNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if item == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = removeNulls(item)
+		}
+
+	case []interface{}:
+		for i, item := range v {
+			v[i] = removeNulls(item)
+		}
+	}
+
+	return value
+}
+
+// itemNames returns the names of the items of list, or nil if some items aren't objects with a unique name.
+func itemNames(list []interface{}) []string {
+	names := make([]string, 0, len(list))
+	seen := map[string]bool{}
+	for _, item := range list {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		name, ok := object["name"].(string)
+		if !ok || seen[name] {
+			return nil
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// diffJSON returns the RFC 6902 operations that turn the JSON value original at path into modified. Objects are diffed by key, lists of named items by name, and other arrays by index, so that items appended by the merge are added, rather than replacing the array.
+func diffJSON(path string, original, modified interface{}) []*patchOp {
+	var ops []*patchOp
+	switch o := original.(type) {
+	case map[string]interface{}:
+		m, ok := modified.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(o)+len(m))
+		for key := range o {
+			keys = append(keys, key)
+		}
+		for key := range m {
+			if _, exists := o[key]; !exists {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := path + "/" + escapeJSONPointer(key)
+			originalValue, inOriginal := o[key]
+			modifiedValue, inModified := m[key]
+			switch {
+			case !inModified:
+				ops = append(ops, &patchOp{Op: "remove", Path: child})
+			case !inOriginal:
+				ops = append(ops, &patchOp{Op: "add", Path: child, Value: modifiedValue})
+			default:
+				ops = append(ops, diffJSON(child, originalValue, modifiedValue)...)
+			}
+		}
+		return ops
+
+	case []interface{}:
+		m, ok := modified.([]interface{})
+		if !ok {
+			break
+		}
+
+		// the merge puts the items of the overlay first, so lists that are merged by name are diffed by name to keep the items of the pod in place
+		if originalNames, modifiedNames := itemNames(o), itemNames(m); originalNames != nil && modifiedNames != nil {
+			index := map[string]int{}
+			for i, name := range modifiedNames {
+				index[name] = i
+			}
+
+			matched := map[int]bool{}
+			for i, name := range originalNames {
+				j, exists := index[name]
+				if !exists {
+					matched = nil
+					break
+				}
+				matched[j] = true
+				ops = append(ops, diffJSON(fmt.Sprintf("%s/%d", path, i), o[i], m[j])...)
+			}
+
+			if matched != nil {
+				next := len(o)
+				for j, item := range m {
+					if !matched[j] {
+						ops = append(ops, &patchOp{Op: "add", Path: fmt.Sprintf("%s/%d", path, next), Value: item})
+						next++
+					}
+				}
+				return ops
+			}
+			ops = nil
+		}
+
+		for i := 0; i < len(o) && i < len(m); i++ {
+			ops = append(ops, diffJSON(fmt.Sprintf("%s/%d", path, i), o[i], m[i])...)
+		}
+
+		for i := len(o); i < len(m); i++ {
+			ops = append(ops, &patchOp{Op: "add", Path: fmt.Sprintf("%s/%d", path, i), Value: m[i]})
+		}
+
+		// items are removed from the end, so that the indices of the remaining items don't change
+		for i := len(o) - 1; i >= len(m); i-- {
+			ops = append(ops, &patchOp{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+		}
+		return ops
+	}
+
+	if reflect.DeepEqual(original, modified) {
+		return nil
+	}
+
+	return []*patchOp{{Op: "replace", Path: path, Value: modified}}
+}
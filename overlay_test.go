@@ -0,0 +1,415 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiffJSON(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		original string
+		modified string
+		expected string
+	}{
+		{
+			name:     "Equal",
+			original: `{"a": [1, {"b": "c"}]}`,
+			modified: `{"a": [1, {"b": "c"}]}`,
+			expected: `null`,
+		},
+		{
+			name:     "Objects",
+			original: `{"a": {"b": 1, "c/d": 2}, "e": 3}`,
+			modified: `{"a": {"b": 2, "c/d": 2, "f": {"g": true}}}`,
+			expected: `[{"op": "replace", "path": "/a/b", "value": 2}, {"op": "add", "path": "/a/f", "value": {"g": true}}, {"op": "remove", "path": "/e"}]`,
+		},
+		{
+			name:     "Arrays",
+			original: `{"a": [1, 2], "b": [1, 2, 3]}`,
+			modified: `{"a": [1, 3, 4, 5], "b": [1]}`,
+			expected: `[{"op": "replace", "path": "/a/1", "value": 3}, {"op": "add", "path": "/a/2", "value": 4}, {"op": "add", "path": "/a/3", "value": 5}, {"op": "remove", "path": "/b/2"}, {"op": "remove", "path": "/b/1"}]`,
+		},
+		{
+			name:     "Named items",
+			original: `{"a": [{"name": "b", "c": 1}, {"name": "d"}]}`,
+			modified: `{"a": [{"name": "e"}, {"name": "d", "c": 2}, {"name": "b", "c": 1}]}`,
+			expected: `[{"op": "add", "path": "/a/1/c", "value": 2}, {"op": "add", "path": "/a/2", "value": {"name": "e"}}]`,
+		},
+		{
+			name:     "Types",
+			original: `{"a": [1], "b": {"c": 1}}`,
+			modified: `{"a": {"c": 1}, "b": "c"}`,
+			expected: `[{"op": "replace", "path": "/a", "value": {"c": 1}}, {"op": "replace", "path": "/b", "value": "c"}]`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var original, modified, expected interface{}
+			for _, v := range []struct {
+				data   string
+				target *interface{}
+			}{
+				{data: testCase.original, target: &original},
+				{data: testCase.modified, target: &modified},
+				{data: testCase.expected, target: &expected},
+			} {
+				if err := json.Unmarshal([]byte(v.data), v.target); err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+			}
+
+			if actual := jsonValue(t, diffJSON("", original, modified)); !reflect.DeepEqual(expected, actual) {
+				t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestOverlayPatch(t *testing.T) {
+	template, err := DecodeTemplate([]byte(`
+overlay:
+  containers:
+  - name: busybox
+    env:
+    - name: HTTP_PROXY
+      value: http://127.0.0.1:8080
+  - name: proxy
+    image: nginx:1.15
+    volumeMounts:
+    - name: proxy-config
+      mountPath: /etc/nginx
+  volumes:
+  - name: proxy-config
+    configMap:
+      name: proxy-config
+pod:
+  labels:
+    mesh: enabled
+`))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	pod, err := test.FixturePod(".", "pod-injection-enabled-00.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	podPatch := NewPodPatch(pod)
+	podPatch.raw = raw
+
+	actual, err := webhook.overlayPatch(context.Background(), "uid", podPatch, template, "v1")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if !actual.Allowed {
+		t.Fatalf("Expected the pod to be allowed. Actual: %+v", actual.Result)
+	}
+
+	var ops []*patchOp
+	if err := json.Unmarshal(actual.Patch, &ops); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	expected := []*patchOp{
+		{Op: "add", Path: "/metadata/annotations", Value: map[string]string{annotationKeySidecarInjection: "false", annotationKeySidecarVersion: "v1"}},
+		{Op: "add", Path: "/metadata/labels/mesh", Value: "enabled"},
+		{Op: "add", Path: "/spec/containers/0/env", Value: []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://127.0.0.1:8080"}}},
+		{Op: "add", Path: "/spec/containers/1", Value: corev1.Container{
+			Name:         "proxy",
+			Image:        "nginx:1.15",
+			VolumeMounts: []corev1.VolumeMount{{Name: "proxy-config", MountPath: "/etc/nginx"}},
+		}},
+		{Op: "add", Path: "/spec/volumes/1", Value: corev1.Volume{
+			Name:         "proxy-config",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-config"}}},
+		}},
+	}
+
+	actualOps := map[string]interface{}{}
+	for _, op := range ops {
+		actualOps[op.Op+" "+op.Path] = op.Value
+	}
+
+	if len(ops) != len(expected) {
+		t.Errorf("Operations mismatch\nExpected: %d\nActual: %s", len(expected), actual.Patch)
+	}
+
+	for _, op := range expected {
+		value, exists := actualOps[op.Op+" "+op.Path]
+		if !exists {
+			t.Errorf("Expected operation %s %s. Actual: %s", op.Op, op.Path, actual.Patch)
+			continue
+		}
+
+		if expectedValue := jsonValue(t, op.Value); !reflect.DeepEqual(expectedValue, value) {
+			t.Errorf("Content mismatch of %s\nExpected: %+v\nActual: %+v", op.Path, expectedValue, value)
+		}
+	}
+}
+
+func TestOverlayViolation(t *testing.T) {
+	overlay := &corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init"}},
+		Containers:     []corev1.Container{{Name: "proxy"}},
+	}
+
+	var testCases = []struct {
+		name     string
+		pod      *corev1.Pod
+		expected string
+	}{
+		{
+			name: "Injected",
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init"}},
+				Containers:     []corev1.Container{{Name: "app"}, {Name: "proxy"}},
+			}},
+		},
+		{
+			name:     "Missing init container",
+			pod:      &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "proxy"}}}},
+			expected: `overlay init container "init" is missing`,
+		},
+		{
+			name: "Missing container",
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init"}},
+				Containers:     []corev1.Container{{Name: "app"}},
+			}},
+			expected: `overlay container "proxy" is missing`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if actual := overlayViolation(testCase.pod, overlay); actual != testCase.expected {
+				t.Errorf("Violation mismatch\nExpected: %q\nActual: %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+// jsonValue returns the generic JSON value of v.
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	return value
+}
+
+func TestUpgradeWorkloadOverlay(t *testing.T) {
+	overlay := func(image string) *corev1.PodSpec {
+		spec := &corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "busybox", Env: []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://127.0.0.1:8080"}}},
+				{Name: "proxy", Image: image, VolumeMounts: []corev1.VolumeMount{{Name: "proxy-config", MountPath: "/etc/nginx"}}},
+			},
+			Volumes: []corev1.Volume{
+				{Name: "proxy-config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-config"}}}},
+			},
+		}
+
+		// the overlay of v2 has an init container that the injected overlay doesn't have
+		if image == "nginx:1.15" {
+			spec.InitContainers = []corev1.Container{{Name: "proxy-init", Image: "proxy-init:v2"}}
+		}
+		return spec
+	}
+
+	// the overlay of v1 is injected into the pod template of a new deployment
+	template := &corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "busybox", Image: "busybox"}}},
+	}
+
+	injected, err := webhook.overlayPatch(context.Background(), "uid", NewPodTemplatePatch(template, patchPrefixPodTemplate), &SidecarTemplate{Overlay: overlay("nginx:1.14")}, "v1")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var ops []*patchOp
+	if err := json.Unmarshal(injected.Patch, &ops); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var hash, items string
+	for _, op := range ops {
+		switch op.Path {
+		case patchPrefixPodTemplate + patchPathAnnotation:
+			hash, _ = op.Value.(map[string]interface{})[annotationKeySidecarHash].(string)
+		case patchPrefixPodTemplate + patchPathAnnotation + "/" + escapeJSONPointer(annotationKeyOverlayItems):
+			items, _ = op.Value.(string)
+		}
+	}
+
+	if hash == "" || items != `{"containers":["busybox","proxy"],"volumes":["proxy-config"]}` {
+		t.Fatalf("Expected the overlay hash and items to be recorded. Actual: %s", injected.Patch)
+	}
+
+	// the pod template is stored with the fields defaulted by the API server
+	var (
+		defaultMode = corev1.ConfigMapVolumeSourceDefaultMode
+		stored      = template.DeepCopy()
+	)
+	stored.ObjectMeta.Annotations = map[string]string{
+		annotationKeySidecarInjection: "false",
+		annotationKeySidecarVersion:   "v1",
+		annotationKeySidecarHash:      hash,
+		annotationKeyOverlayItems:     items,
+	}
+	stored.Spec.Containers = []corev1.Container{
+		{Name: "busybox", Image: "busybox", Env: overlay("").Containers[0].Env, TerminationMessagePath: corev1.TerminationMessagePathDefault, TerminationMessagePolicy: corev1.TerminationMessageReadFile, ImagePullPolicy: corev1.PullAlways},
+		{Name: "proxy", Image: "nginx:1.14", VolumeMounts: overlay("").Containers[1].VolumeMounts, TerminationMessagePath: corev1.TerminationMessagePathDefault, TerminationMessagePolicy: corev1.TerminationMessageReadFile, ImagePullPolicy: corev1.PullIfNotPresent},
+	}
+	stored.Spec.Volumes = overlay("").Volumes
+	stored.Spec.Volumes[0].ConfigMap.DefaultMode = &defaultMode
+
+	var testCases = []struct {
+		name     string
+		version  string
+		edit     func(*corev1.PodTemplateSpec)
+		upgraded bool
+		adopted  bool
+	}{
+		{name: "Up-to-date", version: "v1"},
+		{name: "Outdated", version: "v2", upgraded: true},
+		{
+			name:    "User-edited in an earlier update",
+			version: "v2",
+			edit: func(template *corev1.PodTemplateSpec) {
+				template.Spec.Containers[1].Image = "nginx:1.13"
+			},
+		},
+		{
+			name:    "Edited outside of the overlay",
+			version: "v2",
+			edit: func(template *corev1.PodTemplateSpec) {
+				template.Spec.ServiceAccountName = "worker"
+				template.Spec.Containers = append(template.Spec.Containers, corev1.Container{Name: "worker", Image: "worker:v2"})
+			},
+			upgraded: true,
+		},
+		{
+			name:    "User-edited overlay volume",
+			version: "v2",
+			edit: func(template *corev1.PodTemplateSpec) {
+				template.Spec.Volumes[0].ConfigMap.Name = "custom-proxy-config"
+			},
+		},
+		{
+			name:    "Injected without an overlay hash",
+			version: "v1",
+			edit: func(template *corev1.PodTemplateSpec) {
+				delete(template.ObjectMeta.Annotations, annotationKeySidecarHash)
+			},
+			adopted: true,
+		},
+		{
+			name:    "Outdated and injected without an overlay hash",
+			version: "v2",
+			edit: func(template *corev1.PodTemplateSpec) {
+				delete(template.ObjectMeta.Annotations, annotationKeySidecarHash)
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			current := stored.DeepCopy()
+			if testCase.edit != nil {
+				testCase.edit(current)
+			}
+
+			// the update being admitted doesn't change the pod template
+			raw, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": current}})
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			request := &admissionv1beta1.AdmissionRequest{
+				UID:       "uid",
+				Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Operation: admissionv1beta1.Update,
+			}
+			request.Object.Raw = raw
+			request.OldObject.Raw = raw
+
+			image := "nginx:1.14"
+			if testCase.version == "v2" {
+				image = "nginx:1.15"
+			}
+
+			fixture := *webhook
+			fixture.TemplateSource = staticSource{template: &SidecarTemplate{Overlay: overlay(image), Version: testCase.version}}
+
+			podPatch := NewPodTemplatePatch(current, patchPrefixPodTemplate)
+			if podPatch.raw, err = rawTemplate(raw, patchPrefixPodTemplate); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := fixture.upgradeWorkload(context.Background(), request, podPatch)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if !actual.Allowed {
+				t.Fatalf("Expected workload update to be allowed. Actual: %+v", actual)
+			}
+
+			if !testCase.upgraded && !testCase.adopted {
+				if actual.Patch != nil {
+					t.Errorf("Expected no patch. Actual: %s", actual.Patch)
+				}
+				return
+			}
+
+			var ops []*patchOp
+			if err := json.Unmarshal(actual.Patch, &ops); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actualOps := map[string]interface{}{}
+			for _, op := range ops {
+				actualOps[op.Path] = op.Value
+			}
+
+			if value := actualOps["/spec/template/spec/containers/1/image"]; testCase.upgraded != (value == "nginx:1.15") {
+				t.Errorf("Image mismatch. Expected upgraded: %t. Actual: %s", testCase.upgraded, actual.Patch)
+			}
+
+			if value := actualOps["/spec/template/metadata/annotations/sidecar.example.org~1template-version"]; value != testCase.version {
+				t.Errorf("Version mismatch. Expected: %s. Actual: %s", testCase.version, actual.Patch)
+			}
+
+			if _, exists := actualOps["/spec/template/metadata/annotations/sidecar.example.org~1sidecar-hash"]; !exists {
+				t.Errorf("Expected the overlay hash to be recorded. Actual: %s", actual.Patch)
+			}
+
+			if _, exists := actualOps["/spec/template/metadata/annotations/sidecar.example.org~1overlay-items"]; !exists {
+				t.Errorf("Expected the overlay items to be recorded. Actual: %s", actual.Patch)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	annotationKeySidecarImage         = "sidecar.example.org/image"
+	annotationKeySidecarCPURequest    = "sidecar.example.org/cpu-request"
+	annotationKeySidecarCPULimit      = "sidecar.example.org/cpu-limit"
+	annotationKeySidecarMemoryRequest = "sidecar.example.org/memory-request"
+	annotationKeySidecarMemoryLimit   = "sidecar.example.org/memory-limit"
+	annotationKeySidecarArgs          = "sidecar.example.org/args"
+)
+
+// resourceOverrides maps the allow-listed resource annotations to the fields of the sidecar's resource requirements they override.
+var resourceOverrides = []struct {
+	annotation string
+	name       corev1.ResourceName
+	limit      bool
+}{
+	{annotation: annotationKeySidecarCPURequest, name: corev1.ResourceCPU},
+	{annotation: annotationKeySidecarCPULimit, name: corev1.ResourceCPU, limit: true},
+	{annotation: annotationKeySidecarMemoryRequest, name: corev1.ResourceMemory},
+	{annotation: annotationKeySidecarMemoryLimit, name: corev1.ResourceMemory, limit: true},
+}
+
+// injectedOverrides returns the override annotations of pod that are applied when the sidecar is injected. In the enforced namespaces, the overrides that the validation doesn't honour are ignored, so that the injected pods aren't rejected by the sidecar enforcement.
+func (w *Webhook) injectedOverrides(pod *corev1.Pod) map[string]string {
+	annotations := pod.ObjectMeta.GetAnnotations()
+	if !w.enforced(pod.Namespace) {
+		return annotations
+	}
+
+	overrides := map[string]string{}
+	for key, value := range annotations {
+		if key == annotationKeySidecarArgs || (key == annotationKeySidecarImage && len(w.OverrideRegistries) == 0) {
+			w.logger.Infof("Ignoring annotation %q of pod %s/%s in enforced namespace", key, pod.Namespace, podName(pod))
+			continue
+		}
+		overrides[key] = value
+	}
+
+	return overrides
+}
+
+// applyOverrides overrides the fields of the sidecar container with the values of the allow-listed annotations of the pod. An error is returned if any of the annotation values is invalid.
+func (w *Webhook) applyOverrides(sidecar *corev1.Container, annotations map[string]string) error {
+	if image, exists := annotations[annotationKeySidecarImage]; exists {
+		image = strings.TrimSpace(image)
+		if image == "" {
+			return fmt.Errorf("Invalid value for annotation %q: image can't be empty", annotationKeySidecarImage)
+		}
+
+		if !registryAllowed(image, w.OverrideRegistries) {
+			return fmt.Errorf("Invalid value %q for annotation %q: registry %q isn't allowed. Allowed registries: %s", image, annotationKeySidecarImage, imageRegistry(image), strings.Join(w.OverrideRegistries, ", "))
+		}
+		sidecar.Image = image
+	}
+
+	for _, override := range resourceOverrides {
+		value, exists := annotations[override.annotation]
+		if !exists {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("Invalid value %q for annotation %q: %s", value, override.annotation, err)
+		}
+
+		if override.limit {
+			if sidecar.Resources.Limits == nil {
+				sidecar.Resources.Limits = corev1.ResourceList{}
+			}
+			sidecar.Resources.Limits[override.name] = quantity
+			continue
+		}
+
+		if sidecar.Resources.Requests == nil {
+			sidecar.Resources.Requests = corev1.ResourceList{}
+		}
+		sidecar.Resources.Requests[override.name] = quantity
+	}
+
+	if value, exists := annotations[annotationKeySidecarArgs]; exists {
+		var args []string
+		if err := json.Unmarshal([]byte(value), &args); err != nil {
+			return fmt.Errorf("Invalid value %q for annotation %q: args must be a JSON array of strings", value, annotationKeySidecarArgs)
+		}
+		sidecar.Args = args
+	}
+
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		request, hasRequest := sidecar.Resources.Requests[name]
+		limit, hasLimit := sidecar.Resources.Limits[name]
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			return fmt.Errorf("Invalid sidecar %s resources: request %s can't exceed limit %s", name, request.String(), limit.String())
+		}
+	}
+
+	return nil
+}
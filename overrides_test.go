@@ -0,0 +1,64 @@
+package injector
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	w := &Webhook{OverrideRegistries: []string{"docker.io", "gcr.io"}}
+
+	t.Run("With Valid Annotations", func(t *testing.T) {
+		annotations := map[string]string{
+			annotationKeySidecarImage:         "gcr.io/example/nginx:1.15",
+			annotationKeySidecarCPURequest:    "100m",
+			annotationKeySidecarCPULimit:      "200m",
+			annotationKeySidecarMemoryRequest: "64Mi",
+			annotationKeySidecarMemoryLimit:   "128Mi",
+			annotationKeySidecarArgs:          `["-c", "/etc/nginx/nginx.conf"]`,
+			"sidecar.example.org/unknown":     "ignored",
+		}
+		sidecar := &corev1.Container{Name: "nginx", Image: "nginx"}
+
+		expected := &corev1.Container{
+			Name:  "nginx",
+			Image: "gcr.io/example/nginx:1.15",
+			Args:  []string{"-c", "/etc/nginx/nginx.conf"},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+		}
+
+		if err := w.applyOverrides(sidecar, annotations); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !reflect.DeepEqual(expected, sidecar) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, sidecar)
+		}
+	})
+
+	var invalid = []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{name: "Empty Image", annotations: map[string]string{annotationKeySidecarImage: " "}},
+		{name: "Disallowed Registry", annotations: map[string]string{annotationKeySidecarImage: "quay.io/example/nginx"}},
+		{name: "Invalid Quantity", annotations: map[string]string{annotationKeySidecarCPURequest: "one"}},
+		{name: "Invalid Args", annotations: map[string]string{annotationKeySidecarArgs: "-c /etc/nginx/nginx.conf"}},
+		{name: "Request Exceeds Limit", annotations: map[string]string{annotationKeySidecarMemoryRequest: "1Gi", annotationKeySidecarMemoryLimit: "128Mi"}},
+	}
+
+	for _, testCase := range invalid {
+		t.Run(testCase.name, func(t *testing.T) {
+			sidecar := &corev1.Container{Name: "nginx", Image: "nginx"}
+			if err := w.applyOverrides(sidecar, testCase.annotations); err == nil {
+				t.Error("Expected error didn't occur")
+			}
+		})
+	}
+}
@@ -1,18 +1,32 @@
 package injector
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	corev1 "k8s.io/api/core/v1"
 )
 
 const (
-	patchPathContainer  = "/spec/containers/1"
-	patchPathAnnotation = "/metadata/annotations"
+	patchPathContainer      = "/spec/containers/1"
+	patchPathFirstContainer = "/spec/containers/0"
+	patchPathAnnotation     = "/metadata/annotations"
 )
 
 // PodPatch represents a RFC 6902 patch document for pods.
 type PodPatch struct {
 	original *corev1.Pod
 	patchOps []*patchOp
+
+	// prefix is the path of the pod template within the patched object. It's empty for pods.
+	prefix string
+
+	// annotated is true if the annotations map was added by an earlier operation.
+	annotated bool
+
+	// raw is the JSON of the original pod or pod template in the admission request. It's nil if the patch isn't created from an admission request.
+	raw []byte
 }
 
 // NewPodPatch returns a new instance of PodPatch.
@@ -23,25 +37,135 @@ func NewPodPatch(pod *corev1.Pod) *PodPatch {
 	}
 }
 
+// NewPodTemplatePatch returns a new instance of PodPatch for the pod template found at the prefix path of a workload controller.
+func NewPodTemplatePatch(template *corev1.PodTemplateSpec, prefix string) *PodPatch {
+	pod := &corev1.Pod{
+		ObjectMeta: template.ObjectMeta,
+		Spec:       template.Spec,
+	}
+
+	return &PodPatch{
+		original: pod,
+		patchOps: []*patchOp{},
+		prefix:   prefix,
+	}
+}
+
 func (p *PodPatch) addContainerPatch(container *corev1.Container) {
 	p.patchOps = append(p.patchOps, &patchOp{
 		Op:    "add",
-		Path:  patchPathContainer,
+		Path:  p.prefix + patchPathContainer,
 		Value: container,
 	})
 }
 
-func (p *PodPatch) addAnnotationPatch() {
+// addContainerReplacePatch replaces the container at index of the pod's containers list with container.
+func (p *PodPatch) addContainerReplacePatch(index int, container *corev1.Container) {
+	p.patchOps = append(p.patchOps, &patchOp{
+		Op:    "replace",
+		Path:  fmt.Sprintf("%s/spec/containers/%d", p.prefix, index),
+		Value: container,
+	})
+}
+
+// addFirstContainerPatch inserts container before the containers of the pod, so that the kubelet starts it first.
+func (p *PodPatch) addFirstContainerPatch(container *corev1.Container) {
 	p.patchOps = append(p.patchOps, &patchOp{
 		Op:    "add",
-		Path:  patchPathAnnotation,
-		Value: map[string]string{annotationKeySidecarInjection: "false"},
+		Path:  p.prefix + patchPathFirstContainer,
+		Value: container,
 	})
 }
 
+// addContainerRemovePatch removes the container at index of the pod's containers list.
+func (p *PodPatch) addContainerRemovePatch(index int) {
+	p.patchOps = append(p.patchOps, &patchOp{
+		Op:   "remove",
+		Path: fmt.Sprintf("%s/spec/containers/%d", p.prefix, index),
+	})
+}
+
+// addAnnotationPatch marks the pod as injected with the given version of the sidecar template. The hash of the injected sidecar container is recorded too, unless it's empty.
+func (p *PodPatch) addAnnotationPatch(version, hash string) {
+	annotations := map[string]string{
+		annotationKeySidecarInjection: "false",
+		annotationKeySidecarVersion:   version,
+	}
+	if hash != "" {
+		annotations[annotationKeySidecarHash] = hash
+	}
+	p.addAnnotationsPatch(annotations)
+}
+
+// addAnnotationsPatch adds annotations to the pod, overwriting the existing values of the same keys.
+func (p *PodPatch) addAnnotationsPatch(annotations map[string]string) {
+	p.annotated = p.addMapPatch(patchPathAnnotation, p.original.ObjectMeta.GetAnnotations(), annotations, p.annotated)
+}
+
+// addMapPatch adds the entries of values to the string map at path, whose original entries are existing. Unless the map exists, or is added by an earlier operation, it's added as a whole. It returns true if the map is added as a whole by this or an earlier operation.
+func (p *PodPatch) addMapPatch(path string, existing, values map[string]string, added bool) bool {
+	if len(values) == 0 {
+		return added
+	}
+
+	// replacing the map would remove its existing entries, or the entries added by earlier operations
+	if len(existing) > 0 || added {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			p.patchOps = append(p.patchOps, &patchOp{
+				Op:    "add",
+				Path:  p.prefix + path + "/" + escapeJSONPointer(key),
+				Value: values[key],
+			})
+		}
+		return added
+	}
+
+	p.patchOps = append(p.patchOps, &patchOp{
+		Op:    "add",
+		Path:  p.prefix + path,
+		Value: values,
+	})
+	return true
+}
+
+// addListPatch appends items to the list at path, whose original length is length. If the list is empty, it's added as a whole.
+func (p *PodPatch) addListPatch(path string, length int, items []interface{}) {
+	if len(items) == 0 {
+		return
+	}
+
+	if length == 0 {
+		p.patchOps = append(p.patchOps, &patchOp{
+			Op:    "add",
+			Path:  p.prefix + path,
+			Value: items,
+		})
+		return
+	}
+
+	for _, item := range items {
+		p.patchOps = append(p.patchOps, &patchOp{
+			Op:    "add",
+			Path:  p.prefix + path + "/-",
+			Value: item,
+		})
+	}
+}
+
+// escapeJSONPointer escapes the '~' and '/' characters of a RFC 6901 JSON pointer reference token.
+func escapeJSONPointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
 // patchOp represents a RFC 6902 patch operation.
 type patchOp struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
-	Value interface{} `json:"value",omitempty`
+	Value interface{} `json:"value,omitempty"`
 }
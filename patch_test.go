@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/ihcsim/sidecar-injector/test"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestPodPatch(t *testing.T) {
@@ -21,11 +22,48 @@ func TestPodPatch(t *testing.T) {
 
 	podPatch := NewPodPatch(pod)
 	podPatch.addContainerPatch(sidecar)
-	podPatch.addAnnotationPatch()
+	podPatch.addAnnotationPatch("d89fb08ff4fbd920", "")
 
 	expectedOps := []*patchOp{
 		&patchOp{Op: "add", Path: patchPathContainer, Value: sidecar},
-		&patchOp{Op: "add", Path: patchPathAnnotation, Value: map[string]string{annotationKeySidecarInjection: "false"}},
+		&patchOp{Op: "add", Path: patchPathAnnotation, Value: map[string]string{annotationKeySidecarInjection: "false", annotationKeySidecarVersion: "d89fb08ff4fbd920"}},
+	}
+	expected, err := json.Marshal(expectedOps)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	actual, err := json.Marshal(podPatch.patchOps)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Content mismatch\nExpected: %s\nActual: %s", expected, actual)
+	}
+}
+
+func TestPodTemplatePatch(t *testing.T) {
+	pod, err := test.FixturePod(".", "pod-injection-enabled-01.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	pod.ObjectMeta.Annotations = map[string]string{"prometheus.io/scrape": "true"}
+
+	sidecar, err := test.FixtureContainer(".", "sidecar-container.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	template := &corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}
+	podPatch := NewPodTemplatePatch(template, patchPrefixPodTemplate)
+	podPatch.addContainerReplacePatch(1, sidecar)
+	podPatch.addAnnotationPatch("d89fb08ff4fbd920", "")
+
+	expectedOps := []*patchOp{
+		&patchOp{Op: "replace", Path: "/spec/template/spec/containers/1", Value: sidecar},
+		&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1inject", Value: "false"},
+		&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1template-version", Value: "d89fb08ff4fbd920"},
 	}
 	expected, err := json.Marshal(expectedOps)
 	if err != nil {
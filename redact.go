@@ -0,0 +1,130 @@
+package injector
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveKeys are the substrings of the lower-cased object keys and env var names whose values are redacted.
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "apikey", "api_key", "credential", "private_key", "privatekey"}
+
+// RedactJSON returns a copy of the JSON document data with its Secret-like fields redacted, so that it can be logged. The values of sensitive keys and env vars, and the data of Secret objects are replaced. The base64-encoded JSON patch of an admission response is decoded and redacted. If data isn't valid JSON, a placeholder is returned instead of the original content.
+func RedactJSON(data []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return []byte(`"<invalid JSON redacted>"`)
+	}
+
+	b, err := json.Marshal(redact(doc))
+	if err != nil {
+		return []byte(`"<invalid JSON redacted>"`)
+	}
+
+	return b
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redactObject(v)
+
+	case []interface{}:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+		return v
+	}
+
+	return value
+}
+
+func redactObject(obj map[string]interface{}) map[string]interface{} {
+	if kind, _ := obj["kind"].(string); kind == "Secret" {
+		for _, key := range []string{"data", "stringData"} {
+			if _, exists := obj[key]; exists {
+				obj[key] = redacted
+			}
+		}
+	}
+
+	// env vars such as {"name": "DB_PASSWORD", "value": "..."}
+	if name, ok := obj["name"].(string); ok && sensitive(name) {
+		if _, ok := obj["value"].(string); ok {
+			obj["value"] = redacted
+		}
+	}
+
+	for key, value := range obj {
+		if key == "patch" {
+			if patch, ok := decodePatch(value); ok {
+				obj[key] = redact(patch)
+				continue
+			}
+		}
+
+		if isScalar(value) && sensitive(key) && !isReference(key) {
+			obj[key] = redacted
+			continue
+		}
+
+		obj[key] = redact(value)
+	}
+
+	return obj
+}
+
+// decodePatch decodes the base64-encoded JSON patch of an admission response.
+func decodePatch(value interface{}) (interface{}, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var patch interface{}
+	if err := decoder.Decode(&patch); err != nil {
+		return nil, false
+	}
+
+	return patch, true
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isReference returns true if key refers to another object by name, such as secretName, instead of holding a sensitive value.
+func isReference(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasSuffix(key, "name") || strings.HasSuffix(key, "ref")
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, json.Number:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,51 @@
+package injector
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	patch := base64.StdEncoding.EncodeToString([]byte(`[{"op":"add","path":"/spec/containers/1","value":{"name":"nginx","env":[{"name":"API_TOKEN","value":"s3cr3t"}]}}]`))
+
+	var testCases = []struct {
+		data     string
+		expected string
+	}{
+		{
+			data:     `{"spec":{"containers":[{"name":"app","env":[{"name":"DB_PASSWORD","value":"s3cr3t"},{"name":"DB_HOST","value":"db"}]}]}}`,
+			expected: `{"spec":{"containers":[{"env":[{"name":"DB_PASSWORD","value":"REDACTED"},{"name":"DB_HOST","value":"db"}],"name":"app"}]}}`,
+		},
+		{
+			data:     `{"kind":"Secret","metadata":{"name":"db"},"data":{"password":"czNjcjN0"},"stringData":{"user":"admin"}}`,
+			expected: `{"data":"REDACTED","kind":"Secret","metadata":{"name":"db"},"stringData":"REDACTED"}`,
+		},
+		{
+			data:     `{"volumes":[{"name":"token","secret":{"secretName":"default-token-prdpg"}}],"apiKey":"s3cr3t","automountServiceAccountToken":true,"replicas":1}`,
+			expected: `{"apiKey":"REDACTED","automountServiceAccountToken":true,"replicas":1,"volumes":[{"name":"token","secret":{"secretName":"default-token-prdpg"}}]}`,
+		},
+		{
+			data:     fmt.Sprintf(`{"response":{"allowed":true,"patch":%q}}`, patch),
+			expected: `{"response":{"allowed":true,"patch":[{"op":"add","path":"/spec/containers/1","value":{"env":[{"name":"API_TOKEN","value":"REDACTED"}],"name":"nginx"}}]}}`,
+		},
+		{
+			data:     `{"password":`,
+			expected: `"<invalid JSON redacted>"`,
+		},
+	}
+
+	for id, testCase := range testCases {
+		t.Run(fmt.Sprintf("%d", id), func(t *testing.T) {
+			actual := RedactJSON([]byte(testCase.data))
+			if string(actual) != testCase.expected {
+				t.Errorf("Content mismatch\nExpected: %s\nActual: %s", testCase.expected, actual)
+			}
+
+			if !json.Valid(actual) {
+				t.Errorf("Expected redacted content to be valid JSON. Actual: %s", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package injector
+
+import (
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RolloutReport groups the injected pods by the version of the sidecar template they were injected with.
+type RolloutReport struct {
+	// CurrentVersion is the current version of the sidecar template.
+	CurrentVersion string `json:"currentVersion"`
+
+	// Versions lists the injected pods of each template version, sorted by version.
+	Versions []VersionReport `json:"versions"`
+}
+
+// VersionReport lists the pods that were injected with a version of the sidecar template.
+type VersionReport struct {
+	Version  string   `json:"version"`
+	Outdated bool     `json:"outdated"`
+	Pods     []PodRef `json:"pods"`
+}
+
+// PodRef identifies a pod.
+type PodRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// OutdatedPods returns the number of pods that were injected with an outdated version of the sidecar template.
+func (r *RolloutReport) OutdatedPods() int {
+	count := 0
+	for _, version := range r.Versions {
+		if version.Outdated {
+			count += len(version.Pods)
+		}
+	}
+
+	return count
+}
+
+// Report lists the pods in namespace, and groups the injected pods by the version of the sidecar template they were injected with. Pods in all namespaces are listed if namespace is empty.
+func (w *Webhook) Report(namespace string) (*RolloutReport, error) {
+	current, err := w.TemplateVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := w.Client.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := map[string][]PodRef{}
+	for _, pod := range pods.Items {
+		version, injected := pod.ObjectMeta.GetAnnotations()[annotationKeySidecarVersion]
+		if !injected {
+			continue
+		}
+
+		grouped[version] = append(grouped[version], PodRef{Namespace: pod.Namespace, Name: pod.Name})
+	}
+
+	report := &RolloutReport{
+		CurrentVersion: current,
+		Versions:       []VersionReport{},
+	}
+	for version, refs := range grouped {
+		sort.Slice(refs, func(i, j int) bool {
+			if refs[i].Namespace != refs[j].Namespace {
+				return refs[i].Namespace < refs[j].Namespace
+			}
+			return refs[i].Name < refs[j].Name
+		})
+
+		report.Versions = append(report.Versions, VersionReport{
+			Version:  version,
+			Outdated: version != current,
+			Pods:     refs,
+		})
+	}
+	sort.Slice(report.Versions, func(i, j int) bool {
+		return report.Versions[i].Version < report.Versions[j].Version
+	})
+
+	return report, nil
+}
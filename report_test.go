@@ -0,0 +1,85 @@
+package injector
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReport(t *testing.T) {
+	current, err := webhook.TemplateVersion()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	pods := []*corev1.Pod{
+		reportPod("default", "web-0", current),
+		reportPod("default", "web-1", "0000000000000000"),
+		reportPod("kube-public", "api-0", current),
+		reportPod("kube-public", "batch-0", ""),
+	}
+	for _, pod := range pods {
+		if _, err := webhook.Client.CoreV1().Pods(pod.Namespace).Create(pod); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		defer webhook.Client.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+	}
+
+	t.Run("All Namespaces", func(t *testing.T) {
+		expected := &RolloutReport{
+			CurrentVersion: current,
+			Versions: []VersionReport{
+				{Version: "0000000000000000", Outdated: true, Pods: []PodRef{{Namespace: "default", Name: "web-1"}}},
+				{Version: current, Outdated: false, Pods: []PodRef{{Namespace: "default", Name: "web-0"}, {Namespace: "kube-public", Name: "api-0"}}},
+			},
+		}
+
+		actual, err := webhook.Report("")
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+		}
+
+		if actual.OutdatedPods() != 1 {
+			t.Errorf("Outdated pods mismatch. Expected: 1. Actual: %d", actual.OutdatedPods())
+		}
+	})
+
+	t.Run("Single Namespace", func(t *testing.T) {
+		expected := &RolloutReport{
+			CurrentVersion: current,
+			Versions: []VersionReport{
+				{Version: current, Outdated: false, Pods: []PodRef{{Namespace: "kube-public", Name: "api-0"}}},
+			},
+		}
+
+		actual, err := webhook.Report("kube-public")
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+		}
+	})
+}
+
+func reportPod(namespace, name, version string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+
+	if version != "" {
+		pod.ObjectMeta.Annotations = map[string]string{annotationKeySidecarVersion: version}
+	}
+
+	return pod
+}
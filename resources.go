@@ -0,0 +1,201 @@
+package injector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// quotaResources maps the compute resources tracked by resource quotas to the container resources they are computed from.
+var quotaResources = []struct {
+	quota corev1.ResourceName
+	name  corev1.ResourceName
+	limit bool
+}{
+	{quota: corev1.ResourceCPU, name: corev1.ResourceCPU},
+	{quota: corev1.ResourceRequestsCPU, name: corev1.ResourceCPU},
+	{quota: corev1.ResourceMemory, name: corev1.ResourceMemory},
+	{quota: corev1.ResourceRequestsMemory, name: corev1.ResourceMemory},
+	{quota: corev1.ResourceLimitsCPU, name: corev1.ResourceCPU, limit: true},
+	{quota: corev1.ResourceLimitsMemory, name: corev1.ResourceMemory, limit: true},
+}
+
+// defaultResources fills in the requests and limits of sidecar that aren't set with the defaults. Defaulted requests never exceed the limits.
+func defaultResources(sidecar *corev1.Container, defaults *corev1.ResourceRequirements) {
+	if defaults == nil {
+		return
+	}
+
+	sidecar.Resources.Limits = fillResourceList(sidecar.Resources.Limits, defaults.Limits)
+	sidecar.Resources.Requests = fillResourceList(sidecar.Resources.Requests, defaults.Requests)
+	capRequests(sidecar)
+}
+
+func fillResourceList(resources, defaults corev1.ResourceList) corev1.ResourceList {
+	for name, quantity := range defaults {
+		if _, exists := resources[name]; exists {
+			continue
+		}
+
+		if resources == nil {
+			resources = corev1.ResourceList{}
+		}
+		resources[name] = quantity.DeepCopy()
+	}
+
+	return resources
+}
+
+// capRequests lowers the requests of container that exceed its limits to the limits.
+func capRequests(container *corev1.Container) {
+	for name, limit := range container.Resources.Limits {
+		if request, exists := container.Resources.Requests[name]; exists && request.Cmp(limit) > 0 {
+			container.Resources.Requests[name] = limit.DeepCopy()
+		}
+	}
+}
+
+// applyLimitRanges fills in the unset resources of sidecar with the container defaults of the LimitRanges of namespace, and clamps its resources into their minimums and maximums, so that the pod isn't rejected by the LimitRanger admission plugin. The API server applies the defaults before the webhook is called, so they aren't applied to the sidecar otherwise.
+func (w *Webhook) applyLimitRanges(ctx context.Context, sidecar *corev1.Container, namespace string) error {
+	var list *corev1.LimitRangeList
+	err := withContext(ctx, func() error {
+		var err error
+		list, err = w.Client.CoreV1().LimitRanges(namespace).List(metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list the limit ranges of namespace %s: %s", namespace, err)
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+
+	resources := &sidecar.Resources
+	for _, limitRange := range list.Items {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+
+			resources.Limits = fillResourceList(resources.Limits, item.Default)
+			resources.Requests = fillResourceList(resources.Requests, item.DefaultRequest)
+
+			for name, min := range item.Min {
+				// the API server defaults missing requests to the limits
+				if request, exists := resources.Requests[name]; exists && request.Cmp(min) < 0 || !exists && !hasResource(resources.Limits, name) {
+					resources.Requests = setResource(resources.Requests, name, min)
+				}
+
+				if limit, exists := resources.Limits[name]; exists && limit.Cmp(min) < 0 {
+					resources.Limits[name] = min.DeepCopy()
+				}
+			}
+
+			for name, max := range item.Max {
+				if limit, exists := resources.Limits[name]; !exists || limit.Cmp(max) > 0 {
+					resources.Limits = setResource(resources.Limits, name, max)
+				}
+
+				if request, exists := resources.Requests[name]; exists && request.Cmp(max) > 0 {
+					resources.Requests[name] = max.DeepCopy()
+				}
+			}
+		}
+	}
+	capRequests(sidecar)
+
+	return nil
+}
+
+// quotaViolation returns the reason why pod would exceed one of the resource quotas of its namespace with sidecar, but not without it. An empty string is returned if the sidecar doesn't break any quota. Quotas with scopes are skipped, as they only apply to some of the pods.
+func (w *Webhook) quotaViolation(ctx context.Context, pod *corev1.Pod, sidecar *corev1.Container) (string, error) {
+	var list *corev1.ResourceQuotaList
+	err := withContext(ctx, func() error {
+		var err error
+		list, err = w.Client.CoreV1().ResourceQuotas(pod.Namespace).List(metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to list the resource quotas of namespace %s: %s", pod.Namespace, err)
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+
+	injected := pod.DeepCopy()
+	injected.Spec.Containers = append(injected.Spec.Containers, *sidecar)
+
+	for _, quota := range list.Items {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+
+		for _, r := range quotaResources {
+			hard, exists := quota.Spec.Hard[r.quota]
+			if !exists {
+				continue
+			}
+
+			if !hasResource(containerResources(sidecar, r.limit), r.name) {
+				return fmt.Sprintf("sidecar doesn't specify the %s tracked by resource quota %s", r.quota, quota.Name), nil
+			}
+
+			used := quota.Status.Used[r.quota]
+			without, with := used.DeepCopy(), used.DeepCopy()
+			without.Add(podResource(pod, r.name, r.limit))
+			with.Add(podResource(injected, r.name, r.limit))
+			if with.Cmp(hard) > 0 && without.Cmp(hard) <= 0 {
+				request := containerResources(sidecar, r.limit)[r.name]
+				return fmt.Sprintf("sidecar's %s of %s would exceed resource quota %s: %s would be %s, above the hard limit of %s", r.quota, request.String(), quota.Name, r.quota, with.String(), hard.String()), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// containerResources returns the limits of container, or its requests. Missing requests are defaulted to the limits, like the API server does.
+func containerResources(container *corev1.Container, limits bool) corev1.ResourceList {
+	if limits {
+		return container.Resources.Limits
+	}
+
+	return fillResourceList(container.Resources.Requests.DeepCopy(), container.Resources.Limits)
+}
+
+// podResource returns the quantity of the named resource that pod is charged for by resource quotas, which is the larger of the sum of its containers, and of any of its init containers.
+func podResource(pod *corev1.Pod, name corev1.ResourceName, limits bool) resource.Quantity {
+	var sum resource.Quantity
+	for i := range pod.Spec.Containers {
+		if quantity, exists := containerResources(&pod.Spec.Containers[i], limits)[name]; exists {
+			sum.Add(quantity)
+		}
+	}
+
+	for i := range pod.Spec.InitContainers {
+		if quantity, exists := containerResources(&pod.Spec.InitContainers[i], limits)[name]; exists && quantity.Cmp(sum) > 0 {
+			sum = quantity.DeepCopy()
+		}
+	}
+
+	return sum
+}
+
+func hasResource(resources corev1.ResourceList, name corev1.ResourceName) bool {
+	_, exists := resources[name]
+	return exists
+}
+
+func setResource(resources corev1.ResourceList, name corev1.ResourceName, quantity resource.Quantity) corev1.ResourceList {
+	if resources == nil {
+		resources = corev1.ResourceList{}
+	}
+	resources[name] = quantity.DeepCopy()
+	return resources
+}
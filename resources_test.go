@@ -0,0 +1,211 @@
+package injector
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDefaultResources(t *testing.T) {
+	template, err := DecodeTemplate([]byte(`
+name: nginx
+image: nginx
+resources:
+  limits:
+    cpu: 50m
+defaultResources:
+  requests:
+    cpu: 100m
+    memory: 64Mi
+  limits:
+    cpu: 200m
+    memory: 128Mi
+`))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	sidecar := template.Container.DeepCopy()
+	defaultResources(sidecar, template.DefaultResources)
+
+	expected := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("50m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("50m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	}
+	if !reflect.DeepEqual(expected, sidecar.Resources) {
+		t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, sidecar.Resources)
+	}
+}
+
+func TestApplyLimitRanges(t *testing.T) {
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "default"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				{
+					Type: corev1.LimitTypePod,
+					Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+				},
+				{
+					Type:           corev1.LimitTypeContainer,
+					Default:        corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+					DefaultRequest: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+					Min:            corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+					Max:            corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("192Mi")},
+				},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		name      string
+		resources corev1.ResourceRequirements
+		expected  corev1.ResourceRequirements
+	}{
+		{
+			name: "Defaults",
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("128Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1"),
+					corev1.ResourceMemory: resource.MustParse("192Mi"),
+				},
+			},
+		},
+		{
+			name: "Out of range",
+			resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("10m"),
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("192Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1"),
+					corev1.ResourceMemory: resource.MustParse("192Mi"),
+				},
+			},
+		},
+	}
+
+	fixture := *webhook
+	fixture.Client = fake.NewSimpleClientset(limitRange)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sidecar := &corev1.Container{Name: "nginx", Resources: testCase.resources}
+			if err := fixture.applyLimitRanges(context.Background(), sidecar, "default"); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if !reflect.DeepEqual(testCase.expected, sidecar.Resources) {
+				t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", testCase.expected, sidecar.Resources)
+			}
+		})
+	}
+}
+
+func TestQuotaViolation(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "default"},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+		},
+		Status: corev1.ResourceQuotaStatus{
+			Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("400m")},
+		},
+	}
+
+	scoped := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "best-effort", Namespace: "default"},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard:   corev1.ResourceList{corev1.ResourceLimitsMemory: resource.MustParse("0")},
+			Scopes: []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Resources: resources("500m")}},
+			Containers:     []corev1.Container{{Name: "app", Resources: resources("300m")}},
+		},
+	}
+
+	var testCases = []struct {
+		name     string
+		pod      *corev1.Pod
+		sidecar  corev1.ResourceRequirements
+		expected string
+	}{
+		{
+			name:    "Within quota",
+			pod:     pod,
+			sidecar: resources("100m"),
+		},
+		{
+			name:     "Sidecar exceeds quota",
+			pod:      pod,
+			sidecar:  resources("400m"),
+			expected: "requests.cpu would be 1100m, above the hard limit of 1",
+		},
+		{
+			name:     "Sidecar without requests",
+			pod:      pod,
+			expected: "sidecar doesn't specify the requests.cpu tracked by resource quota compute",
+		},
+		{
+			name: "Pod exceeds quota",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Resources: resources("700m")}},
+				},
+			},
+			sidecar: resources("100m"),
+		},
+	}
+
+	fixture := *webhook
+	fixture.Client = fake.NewSimpleClientset(quota, scoped)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, err := fixture.quotaViolation(context.Background(), testCase.pod, &corev1.Container{Name: "nginx", Resources: testCase.sidecar})
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if testCase.expected == "" && actual != "" || !strings.Contains(actual, testCase.expected) {
+				t.Errorf("Violation mismatch\nExpected: %q\nActual: %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func resources(cpu string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+	}
+}
@@ -0,0 +1,244 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	annotationKeyRestartedAt  = "sidecar.example.org/restarted-at"
+	annotationKeyRestartedFor = "sidecar.example.org/restarted-for-version"
+)
+
+// WorkloadRef identifies a workload controller.
+type WorkloadRef struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (r WorkloadRef) String() string {
+	return fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+// RolloutController watches the sidecar template, and triggers rolling restarts of the deployments, stateful sets and daemon sets whose pods were injected with an outdated version of the template.
+type RolloutController struct {
+	webhook *Webhook
+	logger  *logrus.Logger
+	limiter flowcontrol.RateLimiter
+
+	// Namespaces is the list of namespaces where workloads can be restarted. An empty list allows all namespaces.
+	Namespaces []string
+
+	// DryRun logs the workloads that would be restarted, without restarting them.
+	DryRun bool
+}
+
+// NewRolloutController returns a new instance of RolloutController. At most qps restarts are triggered per second, with bursts of up to burst restarts.
+func NewRolloutController(w *Webhook, qps float32, burst int) *RolloutController {
+	return &RolloutController{
+		webhook: w,
+		logger:  w.logger,
+		limiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+	}
+}
+
+// Run checks for workloads with outdated sidecars at every interval, until stopCh is closed.
+func (c *RolloutController) Run(interval time.Duration, stopCh <-chan struct{}) {
+	c.logger.Infof("Starting rollout controller (interval: %s, namespaces: %v, dry-run: %t)...", interval, c.Namespaces, c.DryRun)
+	wait.Until(func() {
+		if _, err := c.Rollout(); err != nil {
+			c.logger.Error("Failed to roll out sidecar template. Reason: ", err)
+		}
+	}, interval, stopCh)
+}
+
+// Rollout triggers the rolling restarts of the workloads whose pods were injected with an outdated version of the sidecar template. The restarted workloads are returned. Workloads that aren't restarted due to the rate limit are restarted on the next rollout.
+func (c *RolloutController) Rollout() ([]WorkloadRef, error) {
+	version, err := c.webhook.TemplateVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	workloads, err := c.outdatedWorkloads(version)
+	if err != nil {
+		return nil, err
+	}
+
+	restarted := []WorkloadRef{}
+	for _, workload := range workloads {
+		if !c.limiter.TryAccept() {
+			c.logger.Infof("Rate limit reached. Deferring the remaining %d restart(s)", len(workloads)-len(restarted))
+			break
+		}
+
+		if c.DryRun {
+			c.logger.Infof("[dry-run] Restarting %s for sidecar template version %s", workload, version)
+			restarted = append(restarted, workload)
+			continue
+		}
+
+		if err := c.restart(workload, version); err != nil {
+			c.logger.Errorf("Failed to restart %s. Reason: %s", workload, err)
+			continue
+		}
+		c.logger.Infof("Restarted %s for sidecar template version %s", workload, version)
+		restarted = append(restarted, workload)
+	}
+
+	return restarted, nil
+}
+
+// outdatedWorkloads returns the workloads whose pods were injected with a version of the sidecar template other than version. Workloads that were already restarted for version are excluded. Workloads that can't be looked up, e.g. because they were deleted since their pods were listed, are skipped until the next rollout.
+func (c *RolloutController) outdatedWorkloads(version string) ([]WorkloadRef, error) {
+	namespaces := c.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	// the pods of a workload share their controller, which is looked up once
+	controllers := map[WorkloadRef]bool{}
+	for _, namespace := range namespaces {
+		pods, err := c.webhook.Client.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range pods.Items {
+			injected, exists := pod.ObjectMeta.GetAnnotations()[annotationKeySidecarVersion]
+			if !exists || injected == version {
+				continue
+			}
+
+			if ref := metav1.GetControllerOf(&pod); ref != nil {
+				controllers[WorkloadRef{Kind: ref.Kind, Namespace: pod.Namespace, Name: ref.Name}] = true
+			}
+		}
+	}
+
+	found := map[WorkloadRef]bool{}
+	for controller := range controllers {
+		workload, err := c.owner(controller)
+		if err != nil {
+			c.logger.Errorf("Failed to look up the workload of %s. Skipping its pods. Reason: %s", controller, err)
+			continue
+		}
+
+		if workload != nil {
+			found[*workload] = true
+		}
+	}
+
+	workloads := []WorkloadRef{}
+	for workload := range found {
+		restartedFor, err := c.restartedFor(workload)
+		if err != nil {
+			c.logger.Errorf("Failed to look up %s. Skipping it. Reason: %s", workload, err)
+			continue
+		}
+
+		if restartedFor != version {
+			workloads = append(workloads, workload)
+		}
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		return workloads[i].String() < workloads[j].String()
+	})
+
+	return workloads, nil
+}
+
+// owner returns the workload that owns the pods of controller. Nil is returned if the pods aren't owned by a deployment, stateful set or daemon set.
+func (c *RolloutController) owner(controller WorkloadRef) (*WorkloadRef, error) {
+	switch controller.Kind {
+	case "StatefulSet", "DaemonSet":
+		return &controller, nil
+
+	case "ReplicaSet":
+		replicaSet, err := c.webhook.Client.AppsV1().ReplicaSets(controller.Namespace).Get(controller.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		if ref := metav1.GetControllerOf(replicaSet); ref != nil && ref.Kind == "Deployment" {
+			return &WorkloadRef{Kind: ref.Kind, Namespace: controller.Namespace, Name: ref.Name}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// restartedFor returns the sidecar template version that the workload was last restarted for.
+func (c *RolloutController) restartedFor(workload WorkloadRef) (string, error) {
+	var (
+		apps        = c.webhook.Client.AppsV1()
+		opt         = metav1.GetOptions{}
+		annotations map[string]string
+	)
+
+	switch workload.Kind {
+	case "Deployment":
+		deployment, err := apps.Deployments(workload.Namespace).Get(workload.Name, opt)
+		if err != nil {
+			return "", err
+		}
+		annotations = deployment.Spec.Template.GetAnnotations()
+
+	case "StatefulSet":
+		statefulSet, err := apps.StatefulSets(workload.Namespace).Get(workload.Name, opt)
+		if err != nil {
+			return "", err
+		}
+		annotations = statefulSet.Spec.Template.GetAnnotations()
+
+	case "DaemonSet":
+		daemonSet, err := apps.DaemonSets(workload.Namespace).Get(workload.Name, opt)
+		if err != nil {
+			return "", err
+		}
+		annotations = daemonSet.Spec.Template.GetAnnotations()
+	}
+
+	return annotations[annotationKeyRestartedFor], nil
+}
+
+// restart triggers a rolling restart of the workload by patching the annotations of its pod template.
+func (c *RolloutController) restart(workload WorkloadRef, version string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						annotationKeyRestartedAt:  time.Now().UTC().Format(time.RFC3339),
+						annotationKeyRestartedFor: version,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	apps := c.webhook.Client.AppsV1()
+	switch workload.Kind {
+	case "Deployment":
+		_, err = apps.Deployments(workload.Namespace).Patch(workload.Name, types.StrategicMergePatchType, patch)
+	case "StatefulSet":
+		_, err = apps.StatefulSets(workload.Namespace).Patch(workload.Name, types.StrategicMergePatchType, patch)
+	case "DaemonSet":
+		_, err = apps.DaemonSets(workload.Namespace).Patch(workload.Name, types.StrategicMergePatchType, patch)
+	default:
+		err = fmt.Errorf("Unsupported workload kind %s", workload.Kind)
+	}
+
+	return err
+}
@@ -0,0 +1,220 @@
+package injector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRollout(t *testing.T) {
+	var (
+		deployment  = WorkloadRef{Kind: "Deployment", Namespace: "default", Name: "web"}
+		statefulSet = WorkloadRef{Kind: "StatefulSet", Namespace: "data", Name: "db"}
+	)
+
+	t.Run("Dry Run", func(t *testing.T) {
+		w, err := initWebhookWithWorkloads()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		controller := NewRolloutController(w, 10, 10)
+		controller.DryRun = true
+
+		expected := []WorkloadRef{deployment, statefulSet}
+		actual, err := controller.Rollout()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Restarted workloads mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+		}
+
+		d, err := w.Client.AppsV1().Deployments(deployment.Namespace).Get(deployment.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if _, exists := d.Spec.Template.GetAnnotations()[annotationKeyRestartedAt]; exists {
+			t.Error("Expected deployment to not be restarted in dry-run mode")
+		}
+	})
+
+	t.Run("With Namespace Allow-list", func(t *testing.T) {
+		w, err := initWebhookWithWorkloads()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		controller := NewRolloutController(w, 10, 10)
+		controller.Namespaces = []string{"default"}
+
+		expected := []WorkloadRef{deployment}
+		actual, err := controller.Rollout()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Restarted workloads mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+		}
+
+		version, err := w.TemplateVersion()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		d, err := w.Client.AppsV1().Deployments(deployment.Namespace).Get(deployment.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if actual := d.Spec.Template.GetAnnotations()[annotationKeyRestartedFor]; actual != version {
+			t.Errorf("Restarted version mismatch. Expected: %s. Actual: %s", version, actual)
+		}
+
+		// workloads that were already restarted for the current version are skipped
+		actual, err = controller.Rollout()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(actual) != 0 {
+			t.Errorf("Expected no workloads to be restarted again. Actual: %+v", actual)
+		}
+	})
+
+	t.Run("With Deleted Workloads", func(t *testing.T) {
+		w, err := initWebhookWithWorkloads()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		version, err := w.TemplateVersion()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		// the pods of deleted workloads are left until they're garbage collected
+		var (
+			isController = true
+			outdated     = map[string]string{annotationKeySidecarVersion: "0000000000000000"}
+			orphans      = []*corev1.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "old-6b7c8d9e0-k5r2t", Annotations: outdated, OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "old-6b7c8d9e0", Controller: &isController}}}},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "data", Name: "cache-0", Annotations: outdated, OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "cache", Controller: &isController}}}},
+			}
+		)
+		for _, pod := range orphans {
+			if _, err := w.Client.CoreV1().Pods(pod.Namespace).Create(pod); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+		}
+
+		clientset := w.Client.(*test.FakeClient).Interface.(*fake.Clientset)
+		clientset.ClearActions()
+
+		controller := NewRolloutController(w, 10, 10)
+		expected := []WorkloadRef{deployment, statefulSet}
+		actual, err := controller.outdatedWorkloads(version)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Outdated workloads mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+		}
+
+		// the two outdated pods of the web deployment share their replica set
+		var replicaSetGets int
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "get" && action.GetResource().Resource == "replicasets" {
+				replicaSetGets++
+			}
+		}
+
+		if replicaSetGets != 2 {
+			t.Errorf("Replica set lookups mismatch. Expected: 2. Actual: %d", replicaSetGets)
+		}
+	})
+
+	t.Run("With Rate Limit", func(t *testing.T) {
+		w, err := initWebhookWithWorkloads()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		controller := NewRolloutController(w, 0.001, 1)
+
+		actual, err := controller.Rollout()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(actual) != 1 {
+			t.Errorf("Expected one workload to be restarted. Actual: %+v", actual)
+		}
+	})
+}
+
+func initWebhookWithWorkloads() (*Webhook, error) {
+	w, err := initWebhookWithConfigMap()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := w.TemplateVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		isController = true
+		outdated     = map[string]string{annotationKeySidecarVersion: "0000000000000000"}
+		upToDate     = map[string]string{annotationKeySidecarVersion: version}
+		apps         = w.Client.AppsV1()
+		core         = w.Client.CoreV1()
+	)
+
+	if _, err := apps.Deployments("default").Create(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}); err != nil {
+		return nil, err
+	}
+
+	if _, err := apps.Deployments("default").Create(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"}}); err != nil {
+		return nil, err
+	}
+
+	if _, err := apps.StatefulSets("data").Create(&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "data", Name: "db"}}); err != nil {
+		return nil, err
+	}
+
+	replicaSets := []*appsv1.ReplicaSet{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-5d4c9b7f8", OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", Controller: &isController}}}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api-7f8d4c9b5", OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "api", Controller: &isController}}}},
+	}
+	for _, replicaSet := range replicaSets {
+		if _, err := apps.ReplicaSets(replicaSet.Namespace).Create(replicaSet); err != nil {
+			return nil, err
+		}
+	}
+
+	pods := []*corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-5d4c9b7f8-x2kqz", Annotations: outdated, OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d4c9b7f8", Controller: &isController}}}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-5d4c9b7f8-p7wlm", Annotations: outdated, OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d4c9b7f8", Controller: &isController}}}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api-7f8d4c9b5-m4n8q", Annotations: upToDate, OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "api-7f8d4c9b5", Controller: &isController}}}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "busybox", Annotations: outdated}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "data", Name: "db-0", Annotations: outdated, OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "db", Controller: &isController}}}},
+	}
+	for _, pod := range pods {
+		if _, err := core.Pods(pod.Namespace).Create(pod); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
@@ -0,0 +1,87 @@
+package injector
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	patchPathTerminationGracePeriod = "/spec/terminationGracePeriodSeconds"
+
+	// shutdownAllowanceSeconds is the time that the containers get to shut down after SIGTERM, when their preStop hooks return.
+	shutdownAllowanceSeconds = 10
+)
+
+// Shutdown coordinates the termination of the sidecar and the application containers. The kubelet runs the preStop hooks of the containers of a terminating pod concurrently, and sends SIGTERM to each container when its own hook returns. The delays are exec hooks that run the sleep command of the container images, so they fail immediately in images without one, such as distroless images.
+type Shutdown struct {
+	// DrainSeconds delays the termination of the sidecar with a preStop hook, so that it keeps serving the in-flight requests of the application. It must be longer than ApplicationDrainSeconds, so that the sidecar gets SIGTERM after the application containers.
+	DrainSeconds int32 `json:"drainSeconds,omitempty"`
+
+	// ApplicationDrainSeconds delays the termination of the application containers with a preStop hook. The containers that already have a preStop hook keep it.
+	ApplicationDrainSeconds int32 `json:"applicationDrainSeconds,omitempty"`
+}
+
+// gracePeriodSeconds returns the termination grace period that covers the delays of s. The hooks run concurrently within the grace period, so it covers the longest delay, plus the shutdown allowance of the container that gets SIGTERM last. Otherwise, the kubelet would kill it as soon as its hook returns.
+func (s *Shutdown) gracePeriodSeconds() int64 {
+	delay := s.DrainSeconds
+	if s.ApplicationDrainSeconds > delay {
+		delay = s.ApplicationDrainSeconds
+	}
+
+	return int64(delay) + shutdownAllowanceSeconds
+}
+
+// addShutdownHook adds the preStop hook that delays the termination of sidecar, if shutdown has a drain delay.
+func addShutdownHook(sidecar *corev1.Container, shutdown *Shutdown) {
+	if shutdown == nil || shutdown.DrainSeconds == 0 {
+		return
+	}
+
+	if sidecar.Lifecycle == nil {
+		sidecar.Lifecycle = &corev1.Lifecycle{}
+	}
+	sidecar.Lifecycle.PreStop = sleepHandler(shutdown.DrainSeconds)
+}
+
+// sleepHandler returns the hook handler that sleeps for seconds.
+func sleepHandler(seconds int32) *corev1.Handler {
+	return &corev1.Handler{
+		Exec: &corev1.ExecAction{
+			Command: []string{"sleep", fmt.Sprintf("%d", seconds)},
+		},
+	}
+}
+
+// addShutdownPatch adds the preStop hooks of shutdown to the application containers of the pod, except the sidecar container, and extends the termination grace period of the pod if it's shorter than the delays.
+func (p *PodPatch) addShutdownPatch(shutdown *Shutdown, sidecar string) {
+	if shutdown == nil {
+		return
+	}
+	spec := &p.original.Spec
+
+	if shutdown.ApplicationDrainSeconds > 0 {
+		for i, container := range spec.Containers {
+			if container.Name == sidecar {
+				continue
+			}
+
+			path := fmt.Sprintf("%s/spec/containers/%d/lifecycle", p.prefix, i)
+			switch {
+			case container.Lifecycle == nil:
+				p.patchOps = append(p.patchOps, &patchOp{Op: "add", Path: path, Value: &corev1.Lifecycle{PreStop: sleepHandler(shutdown.ApplicationDrainSeconds)}})
+			case container.Lifecycle.PreStop == nil:
+				p.patchOps = append(p.patchOps, &patchOp{Op: "add", Path: path + "/preStop", Value: sleepHandler(shutdown.ApplicationDrainSeconds)})
+			}
+		}
+	}
+
+	current := int64(corev1.DefaultTerminationGracePeriodSeconds)
+	if spec.TerminationGracePeriodSeconds != nil {
+		current = *spec.TerminationGracePeriodSeconds
+	}
+
+	if required := shutdown.gracePeriodSeconds(); required > current {
+		p.patchOps = append(p.patchOps, &patchOp{Op: "add", Path: p.prefix + patchPathTerminationGracePeriod, Value: required})
+	}
+}
@@ -0,0 +1,155 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAddShutdownHook(t *testing.T) {
+	sidecar := &corev1.Container{Name: "nginx"}
+	addShutdownHook(sidecar, &Shutdown{ApplicationDrainSeconds: 5})
+	if sidecar.Lifecycle != nil {
+		t.Errorf("Expected no lifecycle hooks. Actual: %+v", sidecar.Lifecycle)
+	}
+
+	addShutdownHook(sidecar, &Shutdown{DrainSeconds: 15})
+	expected := &corev1.Lifecycle{PreStop: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"sleep", "15"}}}}
+	if !reflect.DeepEqual(expected, sidecar.Lifecycle) {
+		t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, sidecar.Lifecycle)
+	}
+}
+
+func TestShutdownPatch(t *testing.T) {
+	var (
+		gracePeriod = int64(60)
+		preStop     = &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/bin/drain"}}}
+		postStart   = &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/bin/warm-up"}}}
+	)
+
+	var testCases = []struct {
+		name     string
+		shutdown *Shutdown
+		pod      *corev1.Pod
+		expected []*patchOp
+	}{
+		{
+			name:     "Pod without hooks",
+			shutdown: &Shutdown{DrainSeconds: 35, ApplicationDrainSeconds: 5},
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}, {Name: "worker"}},
+			}},
+			expected: []*patchOp{
+				{Op: "add", Path: "/spec/containers/0/lifecycle", Value: &corev1.Lifecycle{PreStop: sleepHandler(5)}},
+				{Op: "add", Path: "/spec/containers/1/lifecycle", Value: &corev1.Lifecycle{PreStop: sleepHandler(5)}},
+				{Op: "add", Path: patchPathTerminationGracePeriod, Value: 45},
+			},
+		},
+		{
+			name:     "Pod with longer grace period",
+			shutdown: &Shutdown{DrainSeconds: 15, ApplicationDrainSeconds: 10},
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}},
+			}},
+			expected: []*patchOp{
+				{Op: "add", Path: "/spec/containers/0/lifecycle", Value: &corev1.Lifecycle{PreStop: sleepHandler(10)}},
+			},
+		},
+		{
+			name:     "Pod with hooks",
+			shutdown: &Shutdown{DrainSeconds: 30, ApplicationDrainSeconds: 5},
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				TerminationGracePeriodSeconds: &gracePeriod,
+				Containers: []corev1.Container{
+					{Name: "app", Lifecycle: &corev1.Lifecycle{PreStop: preStop}},
+					{Name: "worker", Lifecycle: &corev1.Lifecycle{PostStart: postStart}},
+					{Name: "nginx"},
+				},
+			}},
+			expected: []*patchOp{
+				{Op: "add", Path: "/spec/containers/1/lifecycle/preStop", Value: sleepHandler(5)},
+			},
+		},
+		{
+			name:     "Sidecar only",
+			shutdown: &Shutdown{DrainSeconds: 10},
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}},
+			}},
+			expected: []*patchOp{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			podPatch := NewPodPatch(testCase.pod)
+			podPatch.addShutdownPatch(testCase.shutdown, "nginx")
+
+			expected, err := json.Marshal(testCase.expected)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := json.Marshal(podPatch.patchOps)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if string(actual) != string(expected) {
+				t.Errorf("Content mismatch\nExpected: %s\nActual: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestInjectPodSpecShutdown(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "app"}, {Name: "worker", Image: "worker"}},
+		},
+	}
+
+	fixture := *webhook
+	fixture.TemplateSource = staticSource{template: &SidecarTemplate{
+		Container: corev1.Container{Name: "nginx", Image: "nginx"},
+		Version:   "v1",
+		Shutdown:  &Shutdown{DrainSeconds: 45, ApplicationDrainSeconds: 15},
+	}}
+
+	actual, err := fixture.injectPodSpec(context.Background(), "uid", NewPodPatch(pod))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var ops []*patchOp
+	if err := json.Unmarshal(actual.Patch, &ops); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var paths []string
+	for _, op := range ops {
+		paths = append(paths, op.Path)
+	}
+
+	// the hooks of the application containers are added before the sidecar shifts their indices
+	expected := []string{
+		"/spec/containers/0/lifecycle",
+		"/spec/containers/1/lifecycle",
+		patchPathTerminationGracePeriod,
+		patchPathContainer,
+		patchPathAnnotation,
+	}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Fatalf("Content mismatch\nExpected: %+v\nActual: %+v", expected, paths)
+	}
+
+	container := ops[3].Value.(map[string]interface{})
+	if _, exists := container["lifecycle"]; !exists {
+		t.Errorf("Expected the sidecar to have a preStop hook. Actual: %+v", container)
+	}
+}
@@ -0,0 +1,355 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// templateKey and templateKeyYAML are the keys of the JSON and YAML sidecar templates in the sidecar configmaps, and their file names in mounted template directories.
+	templateKey     = "sidecar.json"
+	templateKeyYAML = "sidecar.yaml"
+
+	// The API group, version and resource of the SidecarTemplate custom resources.
+	templateGroup    = "sidecar.example.org"
+	templateVersion  = "v1alpha1"
+	templateResource = "sidecartemplates"
+)
+
+// errTemplateNotFound is returned by the template sources that don't have a sidecar template, so that the next source is consulted.
+var errTemplateNotFound = fmt.Errorf("Sidecar template not found")
+
+// TemplateSource resolves the sidecar template. Sources return errTemplateNotFound if they don't have a template.
+type TemplateSource interface {
+	Template(ctx context.Context) (*SidecarTemplate, error)
+
+	// String describes the source e.g. configmap default/sidecar-spec.
+	String() string
+}
+
+// TemplateSources composes multiple template sources in their precedence order. The template of the first source that has one is used. Errors other than a missing template aren't skipped, so that a failing source never silently falls back to another template.
+type TemplateSources []TemplateSource
+
+// Template returns the template of the first source that has one.
+func (s TemplateSources) Template(ctx context.Context) (*SidecarTemplate, error) {
+	template, _, err := s.resolve(ctx)
+	return template, err
+}
+
+func (s TemplateSources) String() string {
+	names := make([]string, 0, len(s))
+	for _, source := range s {
+		names = append(names, source.String())
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// resolve returns the template of the first source that has one, and that source.
+func (s TemplateSources) resolve(ctx context.Context) (*SidecarTemplate, TemplateSource, error) {
+	for _, source := range s {
+		template, err := source.Template(ctx)
+		if err == errTemplateNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, source, fmt.Errorf("Failed to get sidecar template from %s: %s", source, err)
+		}
+
+		return template, source, nil
+	}
+
+	return nil, nil, fmt.Errorf("Sidecar template not found in %s", s)
+}
+
+// ConfigMapSource reads the sidecar template from the sidecar.json or sidecar.yaml key of a configmap. If Selector is specified, the template is read from the configmaps in Namespace with the matching labels instead, and the first of them by name is used. An empty Namespace selects the configmaps of all namespaces.
+type ConfigMapSource struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Selector  string
+}
+
+// Template returns the template of the configmap.
+func (s *ConfigMapSource) Template(ctx context.Context) (*SidecarTemplate, error) {
+	var configMap *corev1.ConfigMap
+	err := withContext(ctx, func() error {
+		var err error
+		configMap, err = s.configMap()
+		return err
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errTemplateNotFound
+		}
+		return nil, err
+	}
+
+	for _, key := range []string{templateKey, templateKeyYAML} {
+		if data, exists := configMap.Data[key]; exists {
+			return DecodeTemplate([]byte(data))
+		}
+	}
+
+	return nil, errTemplateNotFound
+}
+
+func (s *ConfigMapSource) configMap() (*corev1.ConfigMap, error) {
+	if s.Selector == "" {
+		return s.Client.CoreV1().ConfigMaps(s.Namespace).Get(s.Name, metav1.GetOptions{})
+	}
+
+	list, err := s.Client.CoreV1().ConfigMaps(s.Namespace).List(metav1.ListOptions{LabelSelector: s.Selector})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list.Items) == 0 {
+		return nil, errors.NewNotFound(corev1.Resource("configmaps"), s.Selector)
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Namespace+"/"+list.Items[i].Name < list.Items[j].Namespace+"/"+list.Items[j].Name
+	})
+	return &list.Items[0], nil
+}
+
+func (s *ConfigMapSource) String() string {
+	if s.Selector != "" {
+		return fmt.Sprintf("configmaps %s in namespace %q", s.Selector, s.Namespace)
+	}
+
+	return fmt.Sprintf("configmap %s/%s", s.Namespace, s.Name)
+}
+
+// FileSource reads the sidecar template from a local file, or from the sidecar.json or sidecar.yaml file of a directory, such as a mounted configmap volume. The template is cached, and reloaded when the file is modified.
+type FileSource struct {
+	Path string
+
+	mu       sync.Mutex
+	template *SidecarTemplate
+	modTime  time.Time
+	size     int64
+}
+
+// NewFileSource returns a new instance of FileSource that reads the template from path.
+func NewFileSource(path string) *FileSource {
+	return &FileSource{Path: path}
+}
+
+// Template returns the template of the file. The file is only read if it's modified since it was last read.
+func (s *FileSource) Template(ctx context.Context) (*SidecarTemplate, error) {
+	filename := s.Path
+	info, err := os.Stat(filename)
+	if err == nil && info.IsDir() {
+		for _, key := range []string{templateKey, templateKeyYAML} {
+			filename = filepath.Join(s.Path, key)
+			if info, err = os.Stat(filename); !os.IsNotExist(err) {
+				break
+			}
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errTemplateNotFound
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.template != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.template, nil
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := DecodeTemplate(b)
+	if err != nil {
+		return nil, err
+	}
+
+	s.template, s.modTime, s.size = template, info.ModTime(), info.Size()
+	return template, nil
+}
+
+func (s *FileSource) String() string {
+	return "file " + s.Path
+}
+
+// CRDSource reads the sidecar template from the spec of a SidecarTemplate custom resource.
+type CRDSource struct {
+	Client    rest.Interface
+	Namespace string
+	Name      string
+}
+
+// Template returns the template of the custom resource.
+func (s *CRDSource) Template(ctx context.Context) (*SidecarTemplate, error) {
+	var data []byte
+	err := withContext(ctx, func() error {
+		var err error
+		data, err = s.Client.Get().AbsPath("/apis", templateGroup, templateVersion, "namespaces", s.Namespace, templateResource, s.Name).DoRaw()
+		return err
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errTemplateNotFound
+		}
+		return nil, err
+	}
+
+	var resource struct {
+		Spec json.RawMessage `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return nil, err
+	}
+
+	return DecodeTemplate(resource.Spec)
+}
+
+func (s *CRDSource) String() string {
+	return fmt.Sprintf("%s %s/%s", templateResource, s.Namespace, s.Name)
+}
+
+// HTTPSource fetches the sidecar template from an HTTP(S) URL. The template is cached for the refresh interval, after which it's revalidated with the ETag of the cached response. If the revalidation fails, the stale template is served until the endpoint recovers.
+type HTTPSource struct {
+	URL     string
+	Refresh time.Duration
+
+	client *http.Client
+	logger *logrus.Logger
+
+	mu        sync.Mutex
+	template  *SidecarTemplate
+	etag      string
+	fetchedAt time.Time
+	fetching  bool
+}
+
+// NewHTTPSource returns a new instance of HTTPSource that fetches the template from url. Requests that don't complete within timeout fail.
+func NewHTTPSource(url string, refresh, timeout time.Duration, logger *logrus.Logger) *HTTPSource {
+	return &HTTPSource{
+		URL:     url,
+		Refresh: refresh,
+		client:  &http.Client{Timeout: timeout},
+		logger:  logger,
+	}
+}
+
+// Template returns the cached template, or fetches it if the cache is stale. The lock isn't held during the fetch, and while a stale template is being revalidated, the other callers are served the stale template. If the revalidation fails, the stale template is returned with a logged warning, unless the template is gone from the endpoint.
+func (s *HTTPSource) Template(ctx context.Context) (*SidecarTemplate, error) {
+	s.mu.Lock()
+	cached, etag := s.template, s.etag
+	if cached != nil && (s.fetching || time.Since(s.fetchedAt) < s.Refresh) {
+		s.mu.Unlock()
+		return cached, nil
+	}
+	s.fetching = true
+	s.mu.Unlock()
+
+	template, etag, err := s.fetch(ctx, cached, etag)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetching = false
+
+	if err != nil {
+		if err == errTemplateNotFound {
+			s.template, s.etag = nil, ""
+		}
+
+		if cached == nil || err == errTemplateNotFound {
+			return nil, err
+		}
+
+		s.logger.Warnf("Failed to revalidate the sidecar template at %s. Serving the cached template. Reason: %s", s.URL, err)
+		return cached, nil
+	}
+
+	s.template, s.etag, s.fetchedAt = template, etag, time.Now()
+	return template, nil
+}
+
+// fetch fetches the template from the URL of s. If the endpoint responds that cached is still valid for etag, cached is returned.
+func (s *HTTPSource) fetch(ctx context.Context, cached *SidecarTemplate, etag string) (*SidecarTemplate, string, error) {
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if cached != nil && etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, etag, nil
+
+	case res.StatusCode == http.StatusNotFound:
+		return nil, "", errTemplateNotFound
+
+	case res.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("Template endpoint responded with %s", res.Status)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	template, err := DecodeTemplate(b)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return template, res.Header.Get("ETag"), nil
+}
+
+func (s *HTTPSource) String() string {
+	return "url " + s.URL
+}
+
+// withContext runs fn, and returns its error. The clients don't support contexts, so if ctx is done before fn returns, the error of ctx is returned without waiting for fn.
+func withContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
@@ -0,0 +1,344 @@
+package injector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+const templateJSON = `{"name":"nginx","image":"nginx","ports":[{"name":"http","containerPort":80}],"resources":{}}`
+
+func TestConfigMapSource(t *testing.T) {
+	expected, err := test.FixtureContainer(".", "sidecar-container.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	w, err := initWebhookWithConfigMap()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	labelled := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sidecar-spec-labelled",
+			Namespace: "sidecar-system",
+			Labels:    map[string]string{"sidecar.example.org/template": "true"},
+		},
+		Data: map[string]string{templateKey: `{"name":"envoy","image":"envoy"}`},
+	}
+	if _, err := w.Client.CoreV1().ConfigMaps(labelled.Namespace).Create(labelled); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var testCases = []struct {
+		source   *ConfigMapSource
+		expected *corev1.Container
+		err      error
+	}{
+		{
+			source:   &ConfigMapSource{Client: w.Client, Namespace: defaultNamespace, Name: configMapSidecar},
+			expected: expected,
+		},
+		{
+			source:   &ConfigMapSource{Client: w.Client, Namespace: "sidecar-system", Selector: "sidecar.example.org/template=true"},
+			expected: &corev1.Container{Name: "envoy", Image: "envoy"},
+		},
+		{
+			source: &ConfigMapSource{Client: w.Client, Namespace: defaultNamespace, Name: "missing"},
+			err:    errTemplateNotFound,
+		},
+		{
+			source: &ConfigMapSource{Client: w.Client, Namespace: "sidecar-system", Selector: "sidecar.example.org/template=false"},
+			err:    errTemplateNotFound,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source.String(), func(t *testing.T) {
+			actual, err := testCase.source.Template(context.Background())
+			if err != testCase.err {
+				t.Fatalf("Error mismatch. Expected: %v. Actual: %v", testCase.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(testCase.expected, &actual.Container) {
+				t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", testCase.expected, actual.Container)
+			}
+		})
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, templateKey)
+	for _, path := range []string{filename, dir} {
+		t.Run(path, func(t *testing.T) {
+			source := NewFileSource(path)
+			os.Remove(filename)
+			if _, err := source.Template(context.Background()); err != errTemplateNotFound {
+				t.Fatalf("Error mismatch. Expected: %v. Actual: %v", errTemplateNotFound, err)
+			}
+
+			if err := ioutil.WriteFile(filename, []byte(templateJSON), 0644); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := source.Template(context.Background())
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if actual.Image != "nginx" {
+				t.Errorf("Image mismatch. Expected: nginx. Actual: %s", actual.Image)
+			}
+
+			// the template is reloaded when the file is modified
+			if err := ioutil.WriteFile(filename, []byte(`{"name":"nginx","image":"nginx:1.15"}`), 0644); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			modTime := time.Now().Add(time.Minute)
+			if err := os.Chtimes(filename, modTime, modTime); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err = source.Template(context.Background())
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if actual.Image != "nginx:1.15" {
+				t.Errorf("Image mismatch. Expected: nginx:1.15. Actual: %s", actual.Image)
+			}
+		})
+	}
+}
+
+func TestCRDSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/apis/sidecar.example.org/v1alpha1/namespaces/default/sidecartemplates/sidecar-spec" {
+			http.NotFound(res, req)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(res, `{"apiVersion":"sidecar.example.org/v1alpha1","kind":"SidecarTemplate","metadata":{"name":"sidecar-spec"},"spec":%s}`, templateJSON)
+	}))
+	defer server.Close()
+
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host:    server.URL,
+		APIPath: "/apis",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &schema.GroupVersion{Group: templateGroup, Version: templateVersion},
+			NegotiatedSerializer: scheme.Codecs,
+		},
+	})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	source := &CRDSource{Client: client, Namespace: defaultNamespace, Name: configMapSidecar}
+	actual, err := source.Template(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if actual.Image != "nginx" || len(actual.Ports) != 1 || actual.Ports[0].ContainerPort != 80 {
+		t.Errorf("Content mismatch. Actual: %+v", actual.Container)
+	}
+
+	source.Name = "missing"
+	if _, err := source.Template(context.Background()); err != errTemplateNotFound {
+		t.Errorf("Error mismatch. Expected: %v. Actual: %v", errTemplateNotFound, err)
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	var (
+		mu                 sync.Mutex
+		requests, modified int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+
+		res.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
+		modified++
+		fmt.Fprint(res, templateJSON)
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(server.URL, time.Hour, time.Second, logrus.New())
+	for i := 0; i < 3; i++ {
+		if _, err := source.Template(context.Background()); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Requests mismatch. Expected the cached template to be used. Actual requests: %d", requests)
+	}
+
+	// stale templates are revalidated with their ETag
+	source.Refresh = 0
+	actual, err := source.Template(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if requests != 2 || modified != 1 {
+		t.Errorf("Requests mismatch. Expected: 2 requests, 1 modified. Actual: %d requests, %d modified", requests, modified)
+	}
+
+	if actual.Image != "nginx" {
+		t.Errorf("Image mismatch. Expected: nginx. Actual: %s", actual.Image)
+	}
+}
+
+func TestHTTPSourceStale(t *testing.T) {
+	var (
+		requests = make(chan struct{}, 2)
+		unblock  = make(chan struct{})
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests <- struct{}{}
+		if req.Header.Get("If-None-Match") == "" {
+			res.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(res, templateJSON)
+			return
+		}
+
+		// the revalidation fails after it's unblocked
+		<-unblock
+		http.Error(res, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(server.URL, 0, time.Second, logrus.New())
+	if _, err := source.Template(context.Background()); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	<-requests
+
+	type result struct {
+		template *SidecarTemplate
+		err      error
+	}
+	revalidated := make(chan result, 1)
+	go func() {
+		template, err := source.Template(context.Background())
+		revalidated <- result{template, err}
+	}()
+	<-requests
+
+	// the other callers are served the stale template while it's revalidated
+	template, err := source.Template(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if template.Image != "nginx" {
+		t.Errorf("Image mismatch. Expected: nginx. Actual: %s", template.Image)
+	}
+
+	// the stale template is served if the revalidation fails
+	close(unblock)
+	actual := <-revalidated
+	if actual.err != nil {
+		t.Fatal("Unexpected error: ", actual.err)
+	}
+
+	if actual.template.Image != "nginx" {
+		t.Errorf("Image mismatch. Expected: nginx. Actual: %s", actual.template.Image)
+	}
+}
+
+func TestTemplateSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := initWebhookWithConfigMap()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var (
+		file      = NewFileSource(dir)
+		configMap = w.TemplateSource
+	)
+	w.TemplateSource = TemplateSources{file, configMap}
+
+	// the configmap is used while the directory has no template
+	_, source, _, err := w.resolveTemplate(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if source != configMap {
+		t.Errorf("Source mismatch. Expected: %s. Actual: %s", configMap, source)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, templateKey), []byte(`{"name":"nginx","image":"nginx:1.15"}`), 0644); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	template, source, _, err := w.resolveTemplate(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if source != file || template.Image != "nginx:1.15" {
+		t.Errorf("Source mismatch. Expected: %s. Actual: %s", file, source)
+	}
+
+	// a failing source doesn't fall back to the next source
+	if err := ioutil.WriteFile(filepath.Join(dir, templateKey), []byte("{"), 0644); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, templateKey), modTime, modTime); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if _, _, _, err := w.resolveTemplate(context.Background()); err == nil {
+		t.Error("Expected error to occur")
+	}
+
+	w.TemplateSource = TemplateSources{&ConfigMapSource{Client: w.Client, Namespace: defaultNamespace, Name: "missing"}}
+	if _, _, _, err := w.resolveTemplate(context.Background()); err == nil {
+		t.Error("Expected error to occur")
+	}
+}
@@ -0,0 +1,148 @@
+package injector
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const defaultStartupTimeoutSeconds = 120
+
+// Startup orders the start of the sidecar and the application containers.
+type Startup struct {
+	// HoldApplication injects the sidecar as the first container of the pod, with a postStart hook that waits until the sidecar is ready. The kubelet starts the containers of a pod in order, and doesn't start the next container until the postStart hook of the previous one returns. The hook is a script of the sh shell of the sidecar image.
+	HoldApplication bool `json:"holdApplication,omitempty"`
+
+	// Command tells if the sidecar is ready, by exiting with a zero status. It's run by the postStart hook inside the sidecar. Defaults to a check of the sidecar's readiness probe.
+	Command []string `json:"command,omitempty"`
+
+	// TimeoutSeconds is how long the postStart hook waits for the sidecar to be ready. After the timeout, the kubelet kills the sidecar to restart it, and starts the application containers. Defaults to 120 seconds.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// holdsApplication returns true if the application containers are held until the sidecar is ready.
+func (t *SidecarTemplate) holdsApplication() bool {
+	return t.Startup != nil && t.Startup.HoldApplication
+}
+
+// addStartupHook adds the postStart hook that waits until sidecar is ready, if startup holds the application containers.
+func addStartupHook(sidecar *corev1.Container, startup *Startup) error {
+	if startup == nil || !startup.HoldApplication {
+		return nil
+	}
+
+	check := startup.Command
+	if len(check) == 0 {
+		var err error
+		if check, err = readinessCheck(sidecar); err != nil {
+			return err
+		}
+	}
+
+	timeout := startup.TimeoutSeconds
+	if timeout == 0 {
+		timeout = defaultStartupTimeoutSeconds
+	}
+
+	if sidecar.Lifecycle == nil {
+		sidecar.Lifecycle = &corev1.Lifecycle{}
+	}
+	sidecar.Lifecycle.PostStart = &corev1.Handler{
+		Exec: &corev1.ExecAction{
+			Command: []string{"sh", "-c", waitScript(check, timeout)},
+		},
+	}
+
+	return nil
+}
+
+// readinessCheck returns the command that checks the readiness probe of sidecar once. The HTTP and TCP probes are checked with the wget and nc commands of the sidecar image, so the image needs them along with sh. The certificates of HTTPS probes aren't verified, like the kubelet does, and the HTTP headers of the probe are sent.
+func readinessCheck(sidecar *corev1.Container) ([]string, error) {
+	probe := sidecar.ReadinessProbe
+	if probe == nil {
+		return nil, fmt.Errorf("Sidecar container %q has no readiness probe to wait for", sidecar.Name)
+	}
+
+	switch {
+	case probe.Exec != nil:
+		return probe.Exec.Command, nil
+
+	case probe.HTTPGet != nil:
+		port, err := probePort(sidecar, probe.HTTPGet.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		host := probe.HTTPGet.Host
+		if host == "" {
+			host = "127.0.0.1"
+		}
+
+		scheme := strings.ToLower(string(probe.HTTPGet.Scheme))
+		if scheme == "" {
+			scheme = "http"
+		}
+
+		path := probe.HTTPGet.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		check := []string{"wget", "-q", "-O", "/dev/null"}
+		if scheme == "https" {
+			check = append(check, "--no-check-certificate")
+		}
+		for _, header := range probe.HTTPGet.HTTPHeaders {
+			check = append(check, "--header", fmt.Sprintf("%s: %s", header.Name, header.Value))
+		}
+
+		return append(check, fmt.Sprintf("%s://%s:%d%s", scheme, host, port, path)), nil
+
+	case probe.TCPSocket != nil:
+		port, err := probePort(sidecar, probe.TCPSocket.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		host := probe.TCPSocket.Host
+		if host == "" {
+			host = "127.0.0.1"
+		}
+
+		return []string{"nc", "-z", host, fmt.Sprintf("%d", port)}, nil
+	}
+
+	return nil, fmt.Errorf("Sidecar container %q has no readiness probe handler to wait for", sidecar.Name)
+}
+
+// probePort resolves the number or the name of the port of a probe of sidecar.
+func probePort(sidecar *corev1.Container, port intstr.IntOrString) (int32, error) {
+	if port.Type == intstr.Int {
+		return port.IntVal, nil
+	}
+
+	for _, p := range sidecar.Ports {
+		if p.Name == port.StrVal {
+			return p.ContainerPort, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Sidecar container %q has no port named %q", sidecar.Name, port.StrVal)
+}
+
+// waitScript returns the shell script that runs check every second, until it succeeds, or fails for timeout seconds.
+func waitScript(check []string, timeout int32) string {
+	quoted := make([]string, len(check))
+	for i, arg := range check {
+		quoted[i] = shellQuote(arg)
+	}
+
+	return fmt.Sprintf(`timeout=%d; until %s >/dev/null 2>&1; do timeout=$((timeout-1)); if [ "$timeout" -le 0 ]; then echo "sidecar isn't ready" >&2; exit 1; fi; sleep 1; done`, timeout, strings.Join(quoted, " "))
+}
+
+// shellQuote quotes s as a single word of a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,198 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestAddStartupHook(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		probe    *corev1.Probe
+		startup  *Startup
+		expected string
+	}{
+		{
+			name:     "HTTP probe",
+			probe:    &corev1.Probe{Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: "ready", Port: intstr.FromString("http")}}},
+			startup:  &Startup{HoldApplication: true},
+			expected: `timeout=120; until 'wget' '-q' '-O' '/dev/null' 'http://127.0.0.1:8080/ready' >/dev/null 2>&1; do timeout=$((timeout-1)); if [ "$timeout" -le 0 ]; then echo "sidecar isn't ready" >&2; exit 1; fi; sleep 1; done`,
+		},
+		{
+			name: "HTTPS probe with headers",
+			probe: &corev1.Probe{Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{
+				Path:        "/ready",
+				Port:        intstr.FromInt(8443),
+				Scheme:      corev1.URISchemeHTTPS,
+				HTTPHeaders: []corev1.HTTPHeader{{Name: "Host", Value: "proxy.local"}, {Name: "X-Probe", Value: "it's ready"}},
+			}}},
+			startup:  &Startup{HoldApplication: true},
+			expected: `timeout=120; until 'wget' '-q' '-O' '/dev/null' '--no-check-certificate' '--header' 'Host: proxy.local' '--header' 'X-Probe: it'\''s ready' 'https://127.0.0.1:8443/ready' >/dev/null 2>&1; do timeout=$((timeout-1)); if [ "$timeout" -le 0 ]; then echo "sidecar isn't ready" >&2; exit 1; fi; sleep 1; done`,
+		},
+		{
+			name:     "TCP probe",
+			probe:    &corev1.Probe{Handler: corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(9000)}}},
+			startup:  &Startup{HoldApplication: true, TimeoutSeconds: 30},
+			expected: `timeout=30; until 'nc' '-z' '127.0.0.1' '9000' >/dev/null 2>&1; do timeout=$((timeout-1)); if [ "$timeout" -le 0 ]; then echo "sidecar isn't ready" >&2; exit 1; fi; sleep 1; done`,
+		},
+		{
+			name:     "Command",
+			probe:    &corev1.Probe{Handler: corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(9000)}}},
+			startup:  &Startup{HoldApplication: true, Command: []string{"sh", "-c", "test -f '/tmp/ready'"}},
+			expected: `timeout=120; until 'sh' '-c' 'test -f '\''/tmp/ready'\''' >/dev/null 2>&1; do timeout=$((timeout-1)); if [ "$timeout" -le 0 ]; then echo "sidecar isn't ready" >&2; exit 1; fi; sleep 1; done`,
+		},
+		{
+			name:    "Not held",
+			probe:   &corev1.Probe{Handler: corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(9000)}}},
+			startup: &Startup{TimeoutSeconds: 30},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sidecar := &corev1.Container{
+				Name:           "nginx",
+				Ports:          []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+				ReadinessProbe: testCase.probe,
+			}
+			if err := addStartupHook(sidecar, testCase.startup); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if testCase.expected == "" {
+				if sidecar.Lifecycle != nil {
+					t.Errorf("Expected no lifecycle hooks. Actual: %+v", sidecar.Lifecycle)
+				}
+				return
+			}
+
+			expected := []string{"sh", "-c", testCase.expected}
+			if sidecar.Lifecycle == nil || sidecar.Lifecycle.PostStart == nil || sidecar.Lifecycle.PostStart.Exec == nil || !reflect.DeepEqual(expected, sidecar.Lifecycle.PostStart.Exec.Command) {
+				t.Errorf("Content mismatch\nExpected: %q\nActual: %+v", expected, sidecar.Lifecycle)
+			}
+		})
+	}
+
+	t.Run("No readiness probe", func(t *testing.T) {
+		if err := addStartupHook(&corev1.Container{Name: "nginx"}, &Startup{HoldApplication: true}); err == nil {
+			t.Error("Expected error to occur")
+		}
+	})
+}
+
+func TestHoldApplication(t *testing.T) {
+	template := &SidecarTemplate{
+		Container: corev1.Container{
+			Name:           "proxy",
+			Image:          "nginx:1.15",
+			ReadinessProbe: &corev1.Probe{Handler: corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(9000)}}},
+		},
+		Version: "v2",
+		Startup: &Startup{HoldApplication: true},
+	}
+
+	fixture := *webhook
+	fixture.TemplateSource = staticSource{template: template}
+
+	t.Run("Injection", func(t *testing.T) {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "app"}},
+			},
+		}
+
+		actual, err := fixture.injectPodSpec(context.Background(), "uid", NewPodPatch(pod))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		var ops []*patchOp
+		if err := json.Unmarshal(actual.Patch, &ops); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(ops) == 0 || ops[0].Op != "add" || ops[0].Path != patchPathFirstContainer {
+			t.Fatalf("Expected the sidecar to be added as the first container. Actual: %s", actual.Patch)
+		}
+
+		container := ops[0].Value.(map[string]interface{})
+		if _, exists := container["lifecycle"]; !exists {
+			t.Errorf("Expected the sidecar to have a postStart hook. Actual: %+v", container)
+		}
+	})
+
+	t.Run("Upgrade", func(t *testing.T) {
+		podTemplate := corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				annotationKeySidecarInjection: "false",
+				annotationKeySidecarVersion:   "v1",
+			}},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "app"}, {Name: "proxy", Image: "nginx:1.14"}},
+			},
+		}
+
+		hash, err := sidecarHash(&podTemplate.Spec.Containers[1])
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		podTemplate.ObjectMeta.Annotations[annotationKeySidecarHash] = hash
+
+		raw, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": podTemplate}})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		request := &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			Operation: admissionv1beta1.Update,
+		}
+		request.Object.Raw = raw
+		request.OldObject.Raw = raw
+
+		actual, err := fixture.upgradeWorkload(context.Background(), request, NewPodTemplatePatch(&podTemplate, patchPrefixPodTemplate))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		var ops []*patchOp
+		if err := json.Unmarshal(actual.Patch, &ops); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(ops) < 2 || ops[0].Op != "remove" || ops[0].Path != "/spec/template/spec/containers/1" || ops[1].Op != "add" || ops[1].Path != "/spec/template/spec/containers/0" {
+			t.Fatalf("Expected the sidecar to be moved before the application container. Actual: %s", actual.Patch)
+		}
+	})
+}
+
+func TestInheritHeldApplication(t *testing.T) {
+	template := &SidecarTemplate{
+		Container:  corev1.Container{Name: "proxy", Image: "nginx"},
+		InheritEnv: []string{"LOG_LEVEL"},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annotationKeySidecarVersion: "v1"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "proxy", Env: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}},
+				{Name: "app", Env: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}}},
+			},
+		},
+	}
+
+	expected := []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}}
+	if actual := template.container(pod).Env; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+	}
+}
@@ -0,0 +1,184 @@
+package injector
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SidecarTemplate is the sidecar container spec read from the sidecar configmap. In addition to the standard container fields, it supports directives that tell the webhook which settings of the pod's application container should be inherited by the sidecar.
+type SidecarTemplate struct {
+	corev1.Container
+
+	// Version is the revision of the template. If it isn't specified, the content hash of the template is used.
+	Version string `json:"version,omitempty"`
+
+	// InheritEnv lists the names of the environment variables that are copied from the application container into the sidecar.
+	InheritEnv []string `json:"inheritEnv,omitempty"`
+
+	// InheritResources copies the resource requests and limits of the application container into the sidecar.
+	InheritResources bool `json:"inheritResources,omitempty"`
+
+	// InheritSecurityContext copies the user, group and SELinux settings of the pod and the application container into the sidecar. The privileges of the application container aren't copied.
+	InheritSecurityContext bool `json:"inheritSecurityContext,omitempty"`
+
+	// DefaultResources are the requests and limits of the sidecar that aren't set by the template, the inherited resources or the pod's override annotations.
+	DefaultResources *corev1.ResourceRequirements `json:"defaultResources,omitempty"`
+
+	// Startup orders the start of the sidecar and the application containers.
+	Startup *Startup `json:"startup,omitempty"`
+
+	// Shutdown coordinates the termination of the sidecar and the application containers.
+	Shutdown *Shutdown `json:"shutdown,omitempty"`
+
+	// Overlay is the partial pod spec that is merged into the pod, instead of the sidecar container, with the strategic merge patch semantics of the pod spec e.g. containers and volumes are merged by name.
+	Overlay *corev1.PodSpec `json:"overlay,omitempty"`
+
+	// Pod are the pod-level settings that are merged into the pod, along with the sidecar.
+	Pod *PodMutations `json:"pod,omitempty"`
+
+	// ConflictPolicy decides how the conflicts between the sidecar and the pod are handled. It's one of reject, skip and rename, and defaults to reject.
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+}
+
+// version returns the revision of the template. It's used to tell which revision of the template a pod was injected with.
+func (t *SidecarTemplate) version() (string, error) {
+	if t.Version != "" {
+		return t.Version, nil
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:16], nil
+}
+
+// container returns the sidecar container to be injected into pod. Values explicitly defined in the template always take precedence over inherited values.
+func (t *SidecarTemplate) container(pod *corev1.Pod) *corev1.Container {
+	sidecar := t.Container.DeepCopy()
+	if pod == nil || len(pod.Spec.Containers) == 0 {
+		return sidecar
+	}
+	app := &pod.Spec.Containers[0]
+
+	// the sidecar that holds the application is the first container of the injected pods
+	if _, injected := pod.ObjectMeta.GetAnnotations()[annotationKeySidecarVersion]; injected && len(pod.Spec.Containers) > 1 && app.Name == sidecarName(pod, t.Name) {
+		app = &pod.Spec.Containers[1]
+	}
+
+	if len(t.InheritEnv) > 0 {
+		sidecar.Env = inheritEnv(sidecar.Env, app.Env, t.InheritEnv)
+	}
+
+	if t.InheritResources {
+		sidecar.Resources = inheritResources(sidecar.Resources, app.Resources)
+	}
+
+	if t.InheritSecurityContext {
+		sidecar.SecurityContext = inheritSecurityContext(sidecar.SecurityContext, app.SecurityContext, pod.Spec.SecurityContext)
+	}
+
+	return sidecar
+}
+
+func inheritEnv(sidecar, app []corev1.EnvVar, names []string) []corev1.EnvVar {
+	defined := map[string]bool{}
+	for _, env := range sidecar {
+		defined[env.Name] = true
+	}
+
+	appEnv := map[string]corev1.EnvVar{}
+	for _, env := range app {
+		appEnv[env.Name] = env
+	}
+
+	for _, name := range names {
+		if defined[name] {
+			continue
+		}
+
+		if env, exists := appEnv[name]; exists {
+			sidecar = append(sidecar, *env.DeepCopy())
+			defined[name] = true
+		}
+	}
+
+	return sidecar
+}
+
+func inheritResources(sidecar, app corev1.ResourceRequirements) corev1.ResourceRequirements {
+	merged := *sidecar.DeepCopy()
+	merged.Requests = inheritResourceList(merged.Requests, app.Requests)
+	merged.Limits = inheritResourceList(merged.Limits, app.Limits)
+	return merged
+}
+
+func inheritResourceList(sidecar, app corev1.ResourceList) corev1.ResourceList {
+	if len(app) == 0 {
+		return sidecar
+	}
+
+	if sidecar == nil {
+		sidecar = corev1.ResourceList{}
+	}
+
+	for name, quantity := range app {
+		if _, exists := sidecar[name]; !exists {
+			sidecar[name] = quantity.DeepCopy()
+		}
+	}
+
+	return sidecar
+}
+
+// inheritSecurityContext fills in the unset identity fields of the sidecar's security context with values from the application container's security context. The pod's security context is used as the fallback. The privilege fields, such as privileged and capabilities, are never inherited, so that a privileged application doesn't silently make the sidecar privileged.
+func inheritSecurityContext(sidecar, app *corev1.SecurityContext, pod *corev1.PodSecurityContext) *corev1.SecurityContext {
+	var merged *corev1.SecurityContext
+	if sidecar != nil {
+		merged = sidecar.DeepCopy()
+	} else {
+		merged = &corev1.SecurityContext{}
+	}
+
+	if app != nil {
+		inherited := app.DeepCopy()
+		if merged.SELinuxOptions == nil {
+			merged.SELinuxOptions = inherited.SELinuxOptions
+		}
+		if merged.RunAsUser == nil {
+			merged.RunAsUser = inherited.RunAsUser
+		}
+		if merged.RunAsGroup == nil {
+			merged.RunAsGroup = inherited.RunAsGroup
+		}
+		if merged.RunAsNonRoot == nil {
+			merged.RunAsNonRoot = inherited.RunAsNonRoot
+		}
+	}
+
+	if pod != nil {
+		inherited := pod.DeepCopy()
+		if merged.SELinuxOptions == nil {
+			merged.SELinuxOptions = inherited.SELinuxOptions
+		}
+		if merged.RunAsUser == nil {
+			merged.RunAsUser = inherited.RunAsUser
+		}
+		if merged.RunAsGroup == nil {
+			merged.RunAsGroup = inherited.RunAsGroup
+		}
+		if merged.RunAsNonRoot == nil {
+			merged.RunAsNonRoot = inherited.RunAsNonRoot
+		}
+	}
+
+	if *merged == (corev1.SecurityContext{}) {
+		return sidecar
+	}
+
+	return merged
+}
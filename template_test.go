@@ -0,0 +1,122 @@
+package injector
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSidecarTemplateContainer(t *testing.T) {
+	var (
+		runAsUser       int64 = 1000
+		podRunAsUser    int64 = 2000
+		runAsGroup      int64 = 3000
+		privileged            = true
+		sidecarEnv            = []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://sidecar:3128"}}
+		appEnv                = []corev1.EnvVar{{Name: "TZ", Value: "America/Vancouver"}, {Name: "HTTP_PROXY", Value: "http://app:3128"}, {Name: "SECRET", Value: "secret"}}
+		appResources          = corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("64Mi")}}
+		sidecarResource       = corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")}}
+	)
+
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{RunAsUser: &podRunAsUser},
+			Containers: []corev1.Container{
+				{
+					Name:      "app",
+					Env:       appEnv,
+					Resources: appResources,
+					SecurityContext: &corev1.SecurityContext{
+						RunAsGroup:               &runAsGroup,
+						Privileged:               &privileged,
+						AllowPrivilegeEscalation: &privileged,
+						Capabilities:             &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN"}},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("Without Directives", func(t *testing.T) {
+		template := &SidecarTemplate{Container: corev1.Container{Name: "nginx", Image: "nginx"}}
+		expected := &corev1.Container{Name: "nginx", Image: "nginx"}
+
+		if actual := template.container(pod); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
+		}
+	})
+
+	t.Run("With Inherit Env", func(t *testing.T) {
+		template := &SidecarTemplate{
+			Container:  corev1.Container{Name: "nginx", Env: sidecarEnv},
+			InheritEnv: []string{"TZ", "HTTP_PROXY", "NOT_FOUND"},
+		}
+		expected := []corev1.EnvVar{sidecarEnv[0], appEnv[0]}
+
+		if actual := template.container(pod); !reflect.DeepEqual(expected, actual.Env) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual.Env)
+		}
+	})
+
+	t.Run("With Inherit Resources", func(t *testing.T) {
+		template := &SidecarTemplate{
+			Container:        corev1.Container{Name: "nginx", Resources: sidecarResource},
+			InheritResources: true,
+		}
+		expected := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m"), corev1.ResourceMemory: resource.MustParse("64Mi")}}
+
+		if actual := template.container(pod); !reflect.DeepEqual(expected, actual.Resources) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual.Resources)
+		}
+	})
+
+	t.Run("With Inherit Security Context", func(t *testing.T) {
+		template := &SidecarTemplate{
+			Container:              corev1.Container{Name: "nginx", SecurityContext: &corev1.SecurityContext{RunAsUser: &runAsUser}},
+			InheritSecurityContext: true,
+		}
+		expected := &corev1.SecurityContext{RunAsUser: &runAsUser, RunAsGroup: &runAsGroup}
+
+		if actual := template.container(pod); !reflect.DeepEqual(expected, actual.SecurityContext) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual.SecurityContext)
+		}
+	})
+
+	t.Run("With Inherit Pod Security Context", func(t *testing.T) {
+		template := &SidecarTemplate{
+			Container:              corev1.Container{Name: "nginx"},
+			InheritSecurityContext: true,
+		}
+		expected := &corev1.SecurityContext{RunAsUser: &podRunAsUser, RunAsGroup: &runAsGroup}
+
+		if actual := template.container(pod); !reflect.DeepEqual(expected, actual.SecurityContext) {
+			t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual.SecurityContext)
+		}
+	})
+}
+
+func TestSidecarTemplateVersion(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		template *SidecarTemplate
+		expected string
+	}{
+		{name: "Explicit Version", template: &SidecarTemplate{Container: corev1.Container{Name: "nginx"}, Version: "v1.0.0"}, expected: "v1.0.0"},
+		{name: "Content Hash", template: &SidecarTemplate{Container: corev1.Container{Name: "nginx", Image: "nginx", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 80}}}}, expected: "d89fb08ff4fbd920"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, err := testCase.template.version()
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if actual != testCase.expected {
+				t.Errorf("Version mismatch. Expected: %s. Actual: %s", testCase.expected, actual)
+			}
+		})
+	}
+}
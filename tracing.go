@@ -0,0 +1,404 @@
+package injector
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// headerTraceParent is the W3C Trace Context header that propagates the trace context of the incoming requests.
+const headerTraceParent = "traceparent"
+
+// TraceID and SpanID identify a trace and a span.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the trace context that is propagated to the child spans and across processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Span is a timed operation of a trace. All its methods are no-ops on a nil span, which is returned by a nil tracer.
+type Span struct {
+	tracer *Tracer
+
+	Name       string
+	Context    SpanContext
+	Parent     SpanID
+	Server     bool
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// SetAttribute sets the attribute key of the span to value.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and exports it. Spans are only exported once.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+// SpanExporter exports the finished spans. Exporters must not block the admission requests.
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// Tracer starts the spans of the admission requests, and exports them when they finish. A nil tracer is a no-op tracer.
+type Tracer struct {
+	exporter SpanExporter
+}
+
+// NewTracer returns a new instance of Tracer that exports its spans with exporter.
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Close closes the exporter of t if it's an io.Closer, so that its buffered spans are exported.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	if closer, ok := t.exporter.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+type spanContextKey struct{}
+
+// Start starts a new span that is a child of the span or remote span context in ctx. A new trace is started if ctx has no span context. The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+
+	if parent, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.Parent = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+	rand.Read(span.Context.SpanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, span.Context), span
+}
+
+// StartServer starts the server span of an incoming HTTP request, as a child of the W3C trace context in header, if any.
+func (t *Tracer) StartServer(ctx context.Context, name string, header http.Header) (context.Context, *Span) {
+	if remote, ok := ParseTraceParent(header.Get(headerTraceParent)); ok {
+		ctx = context.WithValue(ctx, spanContextKey{}, remote)
+	}
+
+	ctx, span := t.Start(ctx, name)
+	if span != nil {
+		span.Server = true
+	}
+
+	return ctx, span
+}
+
+// ParseTraceParent parses the value of a W3C traceparent header.
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || sc.TraceID == (TraceID{}) {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || sc.SpanID == (SpanID{}) {
+		return sc, false
+	}
+
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags&0x01 == 0x01
+
+	return sc, true
+}
+
+// TraceParent returns the W3C traceparent header value of sc.
+func TraceParent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// SpanRecorder is a SpanExporter that keeps the finished spans in memory. It's meant to be used in tests.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan records span.
+func (r *SpanRecorder) ExportSpan(span *Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// Spans returns the recorded spans, in the order that they finished.
+func (r *SpanRecorder) Spans() []*Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Span(nil), r.spans...)
+}
+
+// Reset removes all the recorded spans.
+func (r *SpanRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+// OTLPExporter exports the spans in batches to an OpenTelemetry collector, with the OTLP/HTTP JSON protocol. Spans are buffered, and dropped if the buffer is full.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	logger      *logrus.Logger
+	spans       chan *Span
+	batchSize   int
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewOTLPExporter returns a new instance of OTLPExporter that posts the spans of serviceName to the OTLP/HTTP endpoint e.g. http://otel-collector:4318/v1/traces. The buffered spans are exported when batchSize spans are buffered, or at every interval.
+func NewOTLPExporter(endpoint, serviceName string, batchSize int, interval, timeout time.Duration, logger *logrus.Logger) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: timeout},
+		logger:      logger,
+		spans:       make(chan *Span, batchSize*4),
+		batchSize:   batchSize,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run(interval)
+
+	return e
+}
+
+// ExportSpan queues span to be exported. It never blocks.
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		e.logger.Warnf("Trace buffer is full. Dropped span %s of trace %s", span.Name, span.Context.TraceID)
+	}
+}
+
+// Close exports the buffered spans, and stops the background goroutine. ExportSpan must not be called after Close.
+func (e *OTLPExporter) Close() error {
+	close(e.stop)
+	<-e.done
+	return nil
+}
+
+func (e *OTLPExporter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer close(e.done)
+
+	var batch []*Span
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) < e.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-e.stop:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+
+			if len(batch) > 0 {
+				if err := e.export(batch); err != nil {
+					e.logger.Errorf("Failed to export %d span(s) to %s. Reason: %s", len(batch), e.endpoint, err)
+				}
+			}
+			return
+		}
+
+		if err := e.export(batch); err != nil {
+			e.logger.Errorf("Failed to export %d span(s) to %s. Reason: %s", len(batch), e.endpoint, err)
+		}
+		batch = nil
+	}
+}
+
+func (e *OTLPExporter) export(spans []*Span) error {
+	body, err := json.Marshal(otlpRequest(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	res, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("OTLP endpoint responded with %s", res.Status)
+	}
+
+	return nil
+}
+
+// otlpRequest returns the OTLP/JSON ExportTraceServiceRequest of spans.
+func otlpRequest(serviceName string, spans []*Span) map[string]interface{} {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		otlpSpan := map[string]interface{}{
+			"traceId":           span.Context.TraceID.String(),
+			"spanId":            span.Context.SpanID.String(),
+			"name":              span.Name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+		}
+		if span.Server {
+			otlpSpan["kind"] = 2 // SPAN_KIND_SERVER
+		}
+		if span.Parent != (SpanID{}) {
+			otlpSpan["parentSpanId"] = span.Parent.String()
+		}
+		if span.Error != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": 2, "message": span.Error} // STATUS_CODE_ERROR
+		}
+		span.mu.Unlock()
+
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{
+			{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": serviceName}),
+				},
+				"scopeSpans": []map[string]interface{}{
+					{
+						"scope": map[string]interface{}{"name": "github.com/ihcsim/sidecar-injector"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []map[string]interface{} {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	otlpAttributes := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch v := attributes[key].(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprintf("%v", v)}
+		}
+		otlpAttributes = append(otlpAttributes, map[string]interface{}{"key": key, "value": value})
+	}
+
+	return otlpAttributes
+}
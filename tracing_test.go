@@ -0,0 +1,232 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	var testCases = []struct {
+		value    string
+		expected bool
+		sampled  bool
+	}{
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", expected: true, sampled: true},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", expected: true, sampled: false},
+		{value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", expected: false},
+		{value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", expected: false},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", expected: false},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", expected: false},
+		{value: "", expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.value, func(t *testing.T) {
+			sc, ok := ParseTraceParent(testCase.value)
+			if ok != testCase.expected {
+				t.Fatalf("Boolean mismatch. Expected: %t. Actual: %t", testCase.expected, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if sc.Sampled != testCase.sampled {
+				t.Errorf("Sampled mismatch. Expected: %t. Actual: %t", testCase.sampled, sc.Sampled)
+			}
+
+			if actual := TraceParent(sc); actual != testCase.value {
+				t.Errorf("Content mismatch\nExpected: %s\nActual: %s", testCase.value, actual)
+			}
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	data, err := test.FixtureHTTPRequestBody("http-request-body-valid.json", ".")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	w, err := initWebhookWithConfigMap()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	recorder := &SpanRecorder{}
+	w.Tracer = NewTracer(recorder)
+
+	header := http.Header{}
+	header.Set(headerTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := w.Tracer.StartServer(context.Background(), "serve", header)
+	w.Mutate(ctx, data)
+	server.Finish()
+
+	expected := []string{"decode", "exclusions", "template", "policy", "patch", "mutate", "serve"}
+	spans := recorder.Spans()
+	actual := []string{}
+	for _, span := range spans {
+		actual = append(actual, span.Name)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Spans mismatch\nExpected: %v\nActual: %v", expected, actual)
+	}
+
+	for _, span := range spans {
+		if span.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Trace ID mismatch of span %s. Actual: %s", span.Name, span.Context.TraceID)
+		}
+	}
+
+	var (
+		mutate   = spans[5]
+		template = spans[2]
+	)
+	if mutate.Parent != server.Context.SpanID {
+		t.Errorf("Parent mismatch. Expected: %s. Actual: %s", server.Context.SpanID, mutate.Parent)
+	}
+
+	if server.Parent.String() != "00f067aa0ba902b7" || !server.Server {
+		t.Errorf("Expected server span to be a child of the remote span. Actual parent: %s", server.Parent)
+	}
+
+	if template.Parent != mutate.Context.SpanID {
+		t.Errorf("Parent mismatch. Expected: %s. Actual: %s", mutate.Context.SpanID, template.Parent)
+	}
+
+	if template.Attributes["template.version"] != "d89fb08ff4fbd920" {
+		t.Errorf("Template version mismatch. Actual: %v", template.Attributes["template.version"])
+	}
+
+	expectedAttributes := map[string]interface{}{
+		"admission.uid":       "505034df-a300-11e8-b3da-c810c860534d",
+		"admission.kind":      "Pod",
+		"admission.operation": "CREATE",
+		"admission.decision":  DecisionPatched,
+		"k8s.namespace.name":  "default",
+	}
+	if !reflect.DeepEqual(expectedAttributes, mutate.Attributes) {
+		t.Errorf("Attributes mismatch\nExpected: %+v\nActual: %+v", expectedAttributes, mutate.Attributes)
+	}
+
+	t.Run("No-op Tracer", func(t *testing.T) {
+		var tracer *Tracer
+		ctx, span := tracer.Start(context.Background(), "mutate")
+		if span != nil || ctx != context.Background() {
+			t.Errorf("Expected nil tracer to return nil span. Actual: %+v", span)
+		}
+
+		// methods of nil spans are no-ops
+		span.SetAttribute("admission.decision", DecisionPatched)
+		span.Finish()
+	})
+}
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- body
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL+"/v1/traces", "sidecar-injector", 2, time.Minute, time.Second, logrus.New())
+	tracer := NewTracer(exporter)
+
+	ctx, parent := tracer.Start(context.Background(), "mutate")
+	_, child := tracer.Start(ctx, "template")
+	child.SetAttribute("template.version", "d89fb08ff4fbd920")
+	child.Finish()
+	parent.Finish()
+
+	var body map[string]interface{}
+	select {
+	case body = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the spans to be exported")
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(b, &request); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Spans mismatch. Expected: 2. Actual: %d", len(spans))
+	}
+
+	if spans[0].Name != "template" || spans[0].ParentSpanID != parent.Context.SpanID.String() || spans[0].TraceID != parent.Context.TraceID.String() {
+		t.Errorf("Child span mismatch. Actual: %+v", spans[0])
+	}
+
+	if spans[1].Name != "mutate" || spans[1].ParentSpanID != "" {
+		t.Errorf("Root span mismatch. Actual: %+v", spans[1])
+	}
+}
+
+func TestOTLPExporterClose(t *testing.T) {
+	received := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- strings.Count(string(body), `"spanId"`)
+	}))
+	defer server.Close()
+
+	// neither the batch size nor the interval is reached before the exporter is closed
+	exporter := NewOTLPExporter(server.URL+"/v1/traces", "sidecar-injector", 10, time.Hour, time.Second, logrus.New())
+	tracer := NewTracer(exporter)
+
+	for i := 0; i < 3; i++ {
+		_, span := tracer.Start(context.Background(), "mutate")
+		span.Finish()
+	}
+
+	if err := tracer.Close(); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	select {
+	case actual := <-received:
+		if actual != 3 {
+			t.Errorf("Exported spans mismatch. Expected: 3. Actual: %d", actual)
+		}
+	default:
+		t.Fatal("Expected the buffered spans to be exported on close")
+	}
+}
@@ -0,0 +1,196 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Validate verifies that the pod defined in data has the sidecar container, if the pod belongs to one of the enforced namespaces. The admission review object returns contains the original request and the response with the validation decision.
+func (w *Webhook) Validate(ctx context.Context, data []byte) *admissionv1beta1.AdmissionReview {
+	ctx, span := w.Tracer.Start(ctx, "validate")
+	defer span.Finish()
+
+	admissionReview, err := w.decode(ctx, data)
+	defer traceOutcome(span, admissionReview)
+	if err != nil {
+		w.logger.Info("Failed to decode data. Reason: ", err)
+		admissionReview.Response = errorResponse(admissionReview, err)
+		return admissionReview
+	}
+
+	admissionResponse, err := w.validate(ctx, admissionReview)
+	span.RecordError(err)
+	w.respond(ctx, admissionReview, admissionResponse, err, w.ValidationFailOpen)
+
+	responseJSON, _ := json.Marshal(admissionReview.Response)
+	w.logger.Debugf("Admission response: %s", RedactJSON(responseJSON))
+
+	return admissionReview
+}
+
+func (w *Webhook) validate(ctx context.Context, ar *admissionv1beta1.AdmissionReview) (*admissionv1beta1.AdmissionResponse, error) {
+	if ar == nil || ar.Request == nil {
+		return nil, errNilAdmissionReviewInput
+	}
+
+	request := ar.Request
+	allowed := &admissionv1beta1.AdmissionResponse{
+		UID:     request.UID,
+		Allowed: true,
+	}
+
+	if !w.enforced(request.Namespace) {
+		return allowed, nil
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(request.Object.Raw, &pod); err != nil {
+		return nil, err
+	}
+
+	if pod.Namespace == "" {
+		pod.Namespace = request.Namespace
+	}
+
+	if reason := w.Exclusions.excluded(&pod); reason != "" {
+		w.logger.Debugf("Skipping validation of pod %s/%s: %s", pod.Namespace, podName(&pod), reason)
+		return allowed, nil
+	}
+
+	if request.Operation == admissionv1beta1.Update {
+		var oldPod corev1.Pod
+		if err := json.Unmarshal(request.OldObject.Raw, &oldPod); err != nil {
+			return nil, err
+		}
+
+		// the pod was validated when it was created, and the template may have changed since, so updates that keep its containers, such as label and finalizer changes, are always admitted
+		if reflect.DeepEqual(oldPod.Spec.InitContainers, pod.Spec.InitContainers) && reflect.DeepEqual(oldPod.Spec.Containers, pod.Spec.Containers) {
+			return allowed, nil
+		}
+	}
+
+	// the pod's own override annotations could be used to bypass the enforcement, so only the allow-listed image override is honoured
+	expected, template, _, err := w.sidecarWithOverrides(ctx, &pod, w.enforcedOverrides(&pod))
+	if err != nil {
+		return nil, err
+	}
+
+	var required, violation string
+	if template.Overlay != nil {
+		required, violation = "the sidecar overlay", overlayViolation(&pod, template.Overlay)
+	} else {
+		expected.Name = sidecarName(&pod, expected.Name)
+		required, violation = fmt.Sprintf("the sidecar container %q", expected.Name), sidecarViolation(&pod, expected)
+	}
+
+	if violation != "" {
+		return &admissionv1beta1.AdmissionResponse{
+			UID:     request.UID,
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonForbidden,
+				Message: fmt.Sprintf("Namespace %q requires %s: %s", request.Namespace, required, violation),
+			},
+		}, nil
+	}
+
+	return allowed, nil
+}
+
+func (w *Webhook) enforced(namespace string) bool {
+	for _, enforced := range w.EnforcedNamespaces {
+		if namespace == enforced {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enforcedOverrides returns the override annotations of pod that are honoured when the sidecar is validated. The image override is honoured only if the override registries are restricted, as its registry is then allow-listed by the cluster administrator. The other overrides aren't validated, so the sidecar must match the sidecar template without them.
+func (w *Webhook) enforcedOverrides(pod *corev1.Pod) map[string]string {
+	image, exists := pod.ObjectMeta.GetAnnotations()[annotationKeySidecarImage]
+	if !exists || len(w.OverrideRegistries) == 0 {
+		return nil
+	}
+
+	return map[string]string{annotationKeySidecarImage: image}
+}
+
+// sidecarViolation returns the reason why the sidecar container of pod doesn't match the expected container. An empty string is returned if they match.
+func sidecarViolation(pod *corev1.Pod, expected *corev1.Container) string {
+	var actual *corev1.Container
+	for i, container := range pod.Spec.Containers {
+		if container.Name == expected.Name {
+			actual = &pod.Spec.Containers[i]
+			break
+		}
+	}
+
+	if actual == nil {
+		return "sidecar container is missing"
+	}
+
+	if actual.Image != expected.Image {
+		return fmt.Sprintf("sidecar image %q doesn't match the expected image %q", actual.Image, expected.Image)
+	}
+
+	actual, expected = normalizeContainer(actual), normalizeContainer(expected)
+	var drifted = []struct {
+		field            string
+		actual, expected interface{}
+	}{
+		{field: "command", actual: actual.Command, expected: expected.Command},
+		{field: "args", actual: actual.Args, expected: expected.Args},
+		{field: "ports", actual: actual.Ports, expected: expected.Ports},
+		{field: "env", actual: actual.Env, expected: expected.Env},
+		{field: "volumeMounts", actual: actual.VolumeMounts, expected: expected.VolumeMounts},
+	}
+	for _, d := range drifted {
+		if !reflect.DeepEqual(d.actual, d.expected) {
+			return fmt.Sprintf("sidecar %s drifted from the sidecar template", d.field)
+		}
+	}
+
+	return ""
+}
+
+// normalizeContainer returns a copy of the container with the defaults that the API server applies to it, so that it can be compared with containers read from the sidecar template.
+func normalizeContainer(container *corev1.Container) *corev1.Container {
+	normalized := container.DeepCopy()
+	for i := range normalized.Ports {
+		if normalized.Ports[i].Protocol == "" {
+			normalized.Ports[i].Protocol = corev1.ProtocolTCP
+		}
+	}
+
+	if len(normalized.Command) == 0 {
+		normalized.Command = nil
+	}
+	if len(normalized.Args) == 0 {
+		normalized.Args = nil
+	}
+	if len(normalized.Ports) == 0 {
+		normalized.Ports = nil
+	}
+	if len(normalized.Env) == 0 {
+		normalized.Env = nil
+	}
+	for i := range normalized.Env {
+		if from := normalized.Env[i].ValueFrom; from != nil && from.FieldRef != nil && from.FieldRef.APIVersion == "" {
+			from.FieldRef.APIVersion = "v1"
+		}
+	}
+	if len(normalized.VolumeMounts) == 0 {
+		normalized.VolumeMounts = nil
+	}
+
+	return normalized
+}
@@ -0,0 +1,249 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidate(t *testing.T) {
+	webhook.EnforcedNamespaces = []string{test.DefaultNamespace}
+	defer func() {
+		webhook.EnforcedNamespaces = nil
+	}()
+
+	var testCases = []struct {
+		name               string
+		filename           string
+		namespace          string
+		annotations        map[string]string
+		args               []string
+		overrideRegistries []string
+		expected           bool
+	}{
+		{name: "Pod With Sidecar", filename: "pod-with-sidecar.json", namespace: test.DefaultNamespace, expected: true},
+		{name: "Pod Without Sidecar", filename: "pod-injection-disabled.json", namespace: test.DefaultNamespace, expected: false},
+		{name: "Pod With Drifted Sidecar", filename: "pod-with-drifted-sidecar.json", namespace: test.DefaultNamespace, expected: false},
+		{name: "Pod In Unenforced Namespace", filename: "pod-injection-disabled.json", namespace: "kube-public", expected: true},
+		{name: "Pod With Unrestricted Image Override", filename: "pod-with-drifted-sidecar.json", namespace: test.DefaultNamespace, annotations: map[string]string{annotationKeySidecarImage: "nginx:1.14"}, expected: false},
+		{name: "Pod With Allow-listed Image Override", filename: "pod-with-drifted-sidecar.json", namespace: test.DefaultNamespace, annotations: map[string]string{annotationKeySidecarImage: "nginx:1.14"}, overrideRegistries: []string{"docker.io"}, expected: true},
+		{name: "Pod With Disallowed Image Override", filename: "pod-with-drifted-sidecar.json", namespace: test.DefaultNamespace, annotations: map[string]string{annotationKeySidecarImage: "nginx:1.14"}, overrideRegistries: []string{"gcr.io"}, expected: false},
+		{name: "Pod With Args Override", filename: "pod-with-sidecar.json", namespace: test.DefaultNamespace, annotations: map[string]string{annotationKeySidecarArgs: `["-v"]`}, args: []string{"-v"}, expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			admissionReview, err := admissionReviewWithPod(testCase.filename, testCase.namespace)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if testCase.annotations != nil || testCase.args != nil {
+				var pod corev1.Pod
+				if err := json.Unmarshal(admissionReview.Request.Object.Raw, &pod); err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+
+				pod.ObjectMeta.Annotations = testCase.annotations
+				pod.Spec.Containers[1].Args = testCase.args
+				if admissionReview.Request.Object.Raw, err = json.Marshal(pod); err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+			}
+
+			data, err := json.Marshal(admissionReview)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			fixture := *webhook
+			fixture.OverrideRegistries = testCase.overrideRegistries
+			actual := fixture.Validate(context.Background(), data)
+			if actual.Response.UID != admissionReview.Request.UID {
+				t.Errorf("UID mismatch. Expected: %s. Actual: %s", admissionReview.Request.UID, actual.Response.UID)
+			}
+
+			if actual.Response.Allowed != testCase.expected {
+				t.Errorf("Decision mismatch. Expected: %t. Actual: %t. Result: %+v", testCase.expected, actual.Response.Allowed, actual.Response.Result)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	webhook.EnforcedNamespaces = []string{test.DefaultNamespace}
+	defer func() {
+		webhook.EnforcedNamespaces = nil
+	}()
+
+	var testCases = []struct {
+		name        string
+		oldFilename string
+		filename    string
+		expected    bool
+	}{
+		// the pod was created before the sidecar template changed
+		{name: "Metadata Update Of Outdated Pod", oldFilename: "pod-with-drifted-sidecar.json", filename: "pod-with-drifted-sidecar.json", expected: true},
+		{name: "Sidecar Image Update", oldFilename: "pod-with-sidecar.json", filename: "pod-with-drifted-sidecar.json", expected: false},
+		{name: "Sidecar Image Update To Template", oldFilename: "pod-with-drifted-sidecar.json", filename: "pod-with-sidecar.json", expected: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			admissionReview, err := admissionReviewWithPod(testCase.filename, test.DefaultNamespace)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			oldPod, err := test.FixturePod(".", testCase.oldFilename)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			var pod corev1.Pod
+			if err := json.Unmarshal(admissionReview.Request.Object.Raw, &pod); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			pod.ObjectMeta.Labels["version"] = "v2"
+			pod.ObjectMeta.Finalizers = nil
+			if admissionReview.Request.Object.Raw, err = json.Marshal(pod); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			oldPod.ObjectMeta.Finalizers = []string{"example.org/cleanup"}
+			if admissionReview.Request.OldObject.Raw, err = json.Marshal(oldPod); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			admissionReview.Request.Operation = admissionv1beta1.Update
+
+			actual, err := webhook.validate(context.Background(), admissionReview)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if actual.Allowed != testCase.expected {
+				t.Errorf("Decision mismatch. Expected: %t. Actual: %t. Result: %+v", testCase.expected, actual.Allowed, actual.Result)
+			}
+		})
+	}
+}
+
+func TestMutateThenValidate(t *testing.T) {
+	webhook.EnforcedNamespaces = []string{test.DefaultNamespace}
+	defer func() {
+		webhook.EnforcedNamespaces = nil
+	}()
+
+	var testCases = []struct {
+		name               string
+		annotations        map[string]string
+		overrideRegistries []string
+	}{
+		{name: "Args Override", annotations: map[string]string{annotationKeySidecarArgs: `["-v"]`}},
+		{name: "Unrestricted Image Override", annotations: map[string]string{annotationKeySidecarImage: "nginx:1.14"}},
+		{name: "Allow-listed Image Override", annotations: map[string]string{annotationKeySidecarImage: "nginx:1.14"}, overrideRegistries: []string{"docker.io"}},
+		{name: "Resource Override", annotations: map[string]string{annotationKeySidecarCPURequest: "100m"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			admissionReview, err := admissionReviewWithPod("pod-injection-enabled-00.json", test.DefaultNamespace)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			var pod corev1.Pod
+			if err := json.Unmarshal(admissionReview.Request.Object.Raw, &pod); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			pod.ObjectMeta.Annotations = testCase.annotations
+			if admissionReview.Request.Object.Raw, err = json.Marshal(pod); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			data, err := json.Marshal(admissionReview)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			fixture := *webhook
+			fixture.OverrideRegistries = testCase.overrideRegistries
+			mutated := fixture.Mutate(context.Background(), data)
+			if !mutated.Response.Allowed || mutated.Response.Patch == nil {
+				t.Fatalf("Expected the pod to be patched. Actual: %+v", mutated.Response.Result)
+			}
+
+			var ops []struct {
+				Path  string          `json:"path"`
+				Value json.RawMessage `json:"value"`
+			}
+			if err := json.Unmarshal(mutated.Response.Patch, &ops); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			for _, op := range ops {
+				switch {
+				case strings.HasPrefix(op.Path, "/spec/containers/"):
+					var container corev1.Container
+					if err := json.Unmarshal(op.Value, &container); err != nil {
+						t.Fatal("Unexpected error: ", err)
+					}
+					pod.Spec.Containers = append(pod.Spec.Containers, container)
+
+				case op.Path == patchPathAnnotation:
+					if err := json.Unmarshal(op.Value, &pod.ObjectMeta.Annotations); err != nil {
+						t.Fatal("Unexpected error: ", err)
+					}
+
+				case strings.HasPrefix(op.Path, patchPathAnnotation+"/"):
+					var value string
+					if err := json.Unmarshal(op.Value, &value); err != nil {
+						t.Fatal("Unexpected error: ", err)
+					}
+					pod.ObjectMeta.Annotations[strings.Replace(strings.TrimPrefix(op.Path, patchPathAnnotation+"/"), "~1", "/", -1)] = value
+				}
+			}
+
+			// the API server sends the mutated pod to the validating webhook
+			if admissionReview.Request.Object.Raw, err = json.Marshal(pod); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := fixture.validate(context.Background(), admissionReview)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if !actual.Allowed {
+				t.Errorf("Expected the mutated pod to be allowed. Actual: %+v", actual.Result)
+			}
+		})
+	}
+}
+
+func admissionReviewWithPod(filename, namespace string) (*admissionv1beta1.AdmissionReview, error) {
+	admissionReview, err := test.FixtureAdmissionReview("admission-review-request-only.json", ".")
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := test.FixturePod(".", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	admissionReview.Request.Namespace = namespace
+	admissionReview.Request.Object.Raw = raw
+	return admissionReview, nil
+}
@@ -1,9 +1,12 @@
 package injector
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
@@ -11,11 +14,13 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	annotationKeySidecarInjection = "sidecar.example.org/inject"
+	annotationKeySidecarVersion   = "sidecar.example.org/template-version"
 	configMapSidecar              = "sidecar-spec"
 	defaultNamespace              = "default"
 )
@@ -31,6 +36,42 @@ type Webhook struct {
 	logger       *logrus.Logger
 	deserializer runtime.Decoder
 	Client       kubernetes.Interface
+
+	// OverrideRegistries is the list of registries that the sidecar image annotation of a pod can pull from. An empty list allows all registries.
+	OverrideRegistries []string
+
+	// ImagePolicy is enforced on the sidecar image before it's injected. A nil policy accepts all images.
+	ImagePolicy *ImagePolicy
+
+	// EnforcedNamespaces is the list of namespaces where the validating webhook rejects pods that don't have the sidecar container.
+	EnforcedNamespaces []string
+
+	// TemplateSource resolves the sidecar template. It defaults to the sidecar-spec configmap in the default namespace.
+	TemplateSource TemplateSource
+
+	// Exclusions define the pods that are never mutated or validated. Nil exclusions exclude nothing.
+	Exclusions *Exclusions
+
+	// LimitRanges fills in the sidecar resources with the container defaults of the LimitRanges of the pod's namespace, and clamps them into their range.
+	LimitRanges bool
+
+	// ResourceQuotas rejects the pods that would exceed a ResourceQuota of their namespace because of the sidecar, with a message that blames the sidecar.
+	ResourceQuotas bool
+
+	// FailOpen admits the mutation requests unchanged if they can't be handled before their deadline. Otherwise, they are rejected.
+	FailOpen bool
+
+	// ValidationFailOpen admits the validation requests if they can't be handled before their deadline. Otherwise, they are rejected, so that a slow server can't be used to bypass the sidecar enforcement.
+	ValidationFailOpen bool
+
+	// Tracer traces the admission requests. A nil tracer disables tracing.
+	Tracer *Tracer
+
+	// AuditSinks record the mutation decisions of the webhook.
+	AuditSinks []AuditSink
+
+	// WorkloadMutation enables the injection of the sidecar container into the pod templates of workload controllers.
+	WorkloadMutation bool
 }
 
 // New returns a new instance of Webhook.
@@ -50,82 +91,179 @@ func New() (*Webhook, error) {
 		logger:       logger,
 		deserializer: codecs.UniversalDeserializer(),
 		Client:       client,
+		TemplateSource: &ConfigMapSource{
+			Client:    client,
+			Namespace: defaultNamespace,
+			Name:      configMapSidecar,
+		},
+		Exclusions: DefaultExclusions(),
 	}, nil
 }
 
 // Mutate changes the pod spec defined in data by injecting sidecar container spec into the spec. The admission review object returns contains the original request and the response with the mutated pod spec.
-func (w *Webhook) Mutate(data []byte) *admissionv1beta1.AdmissionReview {
-	admissionReview, err := w.decode(data)
+func (w *Webhook) Mutate(ctx context.Context, data []byte) *admissionv1beta1.AdmissionReview {
+	ctx, span := w.Tracer.Start(ctx, "mutate")
+	defer span.Finish()
+
+	admissionReview, err := w.decode(ctx, data)
+	defer traceOutcome(span, admissionReview)
 	if err != nil {
 		w.logger.Info("Failed to decode data. Reason: ", err)
-		admissionReview.Response = &admissionv1beta1.AdmissionResponse{
-			UID: admissionReview.Request.UID,
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
+		admissionReview.Response = errorResponse(admissionReview, err)
 		return admissionReview
 	}
+	defer w.audit(admissionReview)
 
-	admissionResponse, err := w.inject(admissionReview)
-	if err != nil {
-		admissionReview.Response = &admissionv1beta1.AdmissionResponse{
-			UID: admissionReview.Request.UID,
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-		return admissionReview
-	}
-	admissionReview.Response = admissionResponse
+	admissionResponse, err := w.inject(ctx, admissionReview)
+	span.RecordError(err)
+	w.respond(ctx, admissionReview, admissionResponse, err, w.FailOpen)
 
 	requestJSON, _ := json.Marshal(admissionReview.Request)
-	w.logger.Debugf("Admission request: %s", requestJSON)
+	w.logger.Debugf("Admission request: %s", RedactJSON(requestJSON))
 
 	responseJSON, _ := json.Marshal(admissionReview.Response)
-	w.logger.Debugf("Admission response: %s", responseJSON)
+	w.logger.Debugf("Admission response: %s", RedactJSON(responseJSON))
 
 	return admissionReview
 }
 
-func (w *Webhook) decode(data []byte) (*admissionv1beta1.AdmissionReview, error) {
+func (w *Webhook) decode(ctx context.Context, data []byte) (*admissionv1beta1.AdmissionReview, error) {
+	_, span := w.Tracer.Start(ctx, "decode")
+	defer span.Finish()
+
 	admissionReview := admissionv1beta1.AdmissionReview{}
 	_, _, err := w.deserializer.Decode(data, nil, &admissionReview)
+	span.SetAttribute("admission.request.size", len(data))
+	span.RecordError(err)
 	return &admissionReview, err
 }
 
-func (w *Webhook) inject(ar *admissionv1beta1.AdmissionReview) (*admissionv1beta1.AdmissionResponse, error) {
+// traceOutcome sets the identifiers and the decision of the admission request of review as the attributes of span.
+func traceOutcome(span *Span, review *admissionv1beta1.AdmissionReview) {
+	if request := review.Request; request != nil {
+		span.SetAttribute("admission.uid", string(request.UID))
+		span.SetAttribute("admission.kind", request.Kind.Kind)
+		span.SetAttribute("admission.operation", string(request.Operation))
+		span.SetAttribute("k8s.namespace.name", request.Namespace)
+	}
+	span.SetAttribute("admission.decision", Decision(review))
+}
+
+func (w *Webhook) inject(ctx context.Context, ar *admissionv1beta1.AdmissionReview) (*admissionv1beta1.AdmissionResponse, error) {
 	if ar == nil {
 		return nil, errNilAdmissionReviewInput
 	}
 
 	request := ar.Request
-	w.logger.Debugf("Request JSON object: %s", request.Object.Raw)
 
 	var pod corev1.Pod
 	if err := json.Unmarshal(request.Object.Raw, &pod); err != nil {
 		return nil, err
 	}
-	w.logger.Debugf("Pod: %+v", pod)
+	if pod.Namespace == "" {
+		pod.Namespace = request.Namespace
+	}
+
+	podPatch := NewPodPatch(&pod)
+	podPatch.raw = request.Object.Raw
+	return w.injectPodSpec(ctx, ar.Request.UID, podPatch)
+}
 
-	if w.ignore(&pod) {
+// injectPodSpec returns the admission response with the patch that injects the sidecar container into the pod spec of podPatch.
+func (w *Webhook) injectPodSpec(ctx context.Context, uid types.UID, podPatch *PodPatch) (*admissionv1beta1.AdmissionResponse, error) {
+	pod := podPatch.original
+	_, span := w.Tracer.Start(ctx, "exclusions")
+	ignored := w.ignore(pod)
+	span.SetAttribute("sidecar.ignored", ignored)
+	span.Finish()
+	if ignored {
 		return &admissionv1beta1.AdmissionResponse{
-			UID:     ar.Request.UID,
+			UID:     uid,
 			Allowed: true,
 		}, nil
 	}
 
-	opt := metav1.GetOptions{}
-	sidecar, err := w.sidecarFromConfigMap(configMapSidecar, defaultNamespace, opt)
+	sidecar, template, version, err := w.sidecar(ctx, pod)
 	if err != nil {
 		return nil, err
 	}
-	w.logger.Debugf("Sidecar: %+v", sidecar)
 
-	podPatch := NewPodPatch(&pod)
-	podPatch.addContainerPatch(sidecar)
-	podPatch.addAnnotationPatch()
+	if template.Overlay != nil {
+		return w.overlayPatch(ctx, uid, podPatch, template, version)
+	}
+	w.logger.Debugf("Sidecar: %s (image: %s)", sidecar.Name, sidecar.Image)
 
+	_, span = w.Tracer.Start(ctx, "patch")
+	defer span.Finish()
+
+	injected, conflicts := resolveConflicts(pod, sidecar, template.ConflictPolicy)
+	span.SetAttribute("sidecar.conflicts", len(conflicts))
+	if len(conflicts) > 0 {
+		if template.ConflictPolicy == ConflictPolicySkip {
+			w.logger.Infof("Skipping injection of pod %s/%s. Sidecar container %q conflicts with the pod: %s", pod.Namespace, podName(pod), sidecar.Name, conflictMessage(conflicts))
+			return &admissionv1beta1.AdmissionResponse{
+				UID:     uid,
+				Allowed: true,
+			}, nil
+		}
+
+		return &admissionv1beta1.AdmissionResponse{
+			UID:     uid,
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonForbidden,
+				Message: fmt.Sprintf("Sidecar container %q conflicts with pod %s/%s: %s", sidecar.Name, pod.Namespace, podName(pod), conflictMessage(conflicts)),
+			},
+		}, nil
+	}
+
+	// resource quotas are charged for pods, rather than for the pod templates of workload controllers
+	if w.ResourceQuotas && podPatch.prefix == "" {
+		violation, err := w.quotaViolation(ctx, pod, injected)
+		if err != nil {
+			return nil, err
+		}
+
+		if violation != "" {
+			return &admissionv1beta1.AdmissionResponse{
+				UID:     uid,
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Reason:  metav1.StatusReasonForbidden,
+					Message: fmt.Sprintf("Sidecar container %q can't be injected into pod %s/%s: %s", injected.Name, pod.Namespace, podName(pod), violation),
+				},
+			}, nil
+		}
+	}
+
+	// the application containers are patched before the sidecar is inserted, which shifts their indices
+	podPatch.addShutdownPatch(template.Shutdown, injected.Name)
+	if template.holdsApplication() {
+		podPatch.addFirstContainerPatch(injected)
+	} else {
+		podPatch.addContainerPatch(injected)
+	}
+	// the sidecar of a pod template is upgraded only until it's edited, which is told by its hash
+	var hash string
+	if podPatch.prefix != "" {
+		if hash, err = sidecarHash(injected); err != nil {
+			return nil, err
+		}
+	}
+	podPatch.addAnnotationPatch(version, hash)
+	if injected.Name != sidecar.Name {
+		w.logger.Debugf("Renamed sidecar container %q to %q", sidecar.Name, injected.Name)
+		podPatch.addAnnotationsPatch(map[string]string{annotationKeySidecarContainer: injected.Name})
+	}
+	podPatch.addPodMutationsPatch(template.Pod)
+	span.SetAttribute("patch.operations", len(podPatch.patchOps))
+
+	return patchResponse(uid, podPatch)
+}
+
+func patchResponse(uid types.UID, podPatch *PodPatch) (*admissionv1beta1.AdmissionResponse, error) {
 	patchJSON, err := json.Marshal(podPatch.patchOps)
 	if err != nil {
 		return nil, err
@@ -133,7 +271,7 @@ func (w *Webhook) inject(ar *admissionv1beta1.AdmissionReview) (*admissionv1beta
 
 	patchType := admissionv1beta1.PatchTypeJSONPatch
 	admissionResponse := &admissionv1beta1.AdmissionResponse{
-		UID:       ar.Request.UID,
+		UID:       uid,
 		Allowed:   true,
 		Patch:     patchJSON,
 		PatchType: &patchType,
@@ -142,7 +280,123 @@ func (w *Webhook) inject(ar *admissionv1beta1.AdmissionReview) (*admissionv1beta
 	return admissionResponse, nil
 }
 
+// sidecar resolves the sidecar container of pod from the sidecar template, the pod's override annotations and the image policy. The sidecar template and its version are also returned. Overlay templates don't have a sidecar container, so it's nil for them.
+func (w *Webhook) sidecar(ctx context.Context, pod *corev1.Pod) (*corev1.Container, *SidecarTemplate, string, error) {
+	return w.sidecarWithOverrides(ctx, pod, w.injectedOverrides(pod))
+}
+
+// sidecarWithOverrides resolves the sidecar container of pod like sidecar does, but with the override annotations in overrides, instead of the annotations of the pod.
+func (w *Webhook) sidecarWithOverrides(ctx context.Context, pod *corev1.Pod, overrides map[string]string) (*corev1.Container, *SidecarTemplate, string, error) {
+	template, version, err := w.template(ctx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if template.Overlay != nil {
+		return nil, template, version, nil
+	}
+
+	_, span := w.Tracer.Start(ctx, "policy")
+	defer span.Finish()
+
+	sidecar := template.container(pod)
+	if err := w.applyOverrides(sidecar, overrides); err != nil {
+		span.RecordError(err)
+		return nil, nil, "", err
+	}
+
+	defaultResources(sidecar, template.DefaultResources)
+	if w.LimitRanges {
+		if err := w.applyLimitRanges(ctx, sidecar, pod.Namespace); err != nil {
+			span.RecordError(err)
+			return nil, nil, "", err
+		}
+	}
+
+	if err := addStartupHook(sidecar, template.Startup); err != nil {
+		span.RecordError(err)
+		return nil, nil, "", err
+	}
+	addShutdownHook(sidecar, template.Shutdown)
+
+	if sidecar.Image, err = w.ImagePolicy.apply(sidecar.Image); err != nil {
+		span.RecordError(err)
+		return nil, nil, "", err
+	}
+	span.SetAttribute("sidecar.image", sidecar.Image)
+
+	return sidecar, template, version, nil
+}
+
+// template looks up the sidecar template and its version.
+func (w *Webhook) template(ctx context.Context) (*SidecarTemplate, string, error) {
+	ctx, span := w.Tracer.Start(ctx, "template")
+	defer span.Finish()
+
+	template, source, version, err := w.resolveTemplate(ctx)
+	if source != nil {
+		span.SetAttribute("template.name", source.String())
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, "", err
+	}
+	span.SetAttribute("template.version", version)
+
+	return template, version, nil
+}
+
+// resolveTemplate returns the sidecar template of the webhook's template sources, the source that it's read from, and its version.
+func (w *Webhook) resolveTemplate(ctx context.Context) (*SidecarTemplate, TemplateSource, string, error) {
+	sources, ok := w.TemplateSource.(TemplateSources)
+	if !ok {
+		sources = TemplateSources{w.TemplateSource}
+	}
+
+	template, source, err := sources.resolve(ctx)
+	if err != nil {
+		return nil, source, "", err
+	}
+
+	version, err := template.version()
+	return template, source, version, err
+}
+
+// TemplateVersion returns the current version of the sidecar template.
+func (w *Webhook) TemplateVersion() (string, error) {
+	_, _, version, err := w.resolveTemplate(context.Background())
+	return version, err
+}
+
+// Close closes the audit sinks and the tracer of the webhook, so that their buffered records and spans aren't lost when the server exits.
+func (w *Webhook) Close() error {
+	var errs []string
+	for _, sink := range w.AuditSinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+
+	if err := w.Tracer.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Failed to close the webhook: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// ignore returns true if pod is excluded, or if its sidecar injection annotation is set to false.
 func (w *Webhook) ignore(pod *corev1.Pod) bool {
+	if reason := w.Exclusions.excluded(pod); reason != "" {
+		w.logger.Debugf("Ignoring pod %s/%s: %s", pod.Namespace, podName(pod), reason)
+		return true
+	}
+
 	annotations := pod.ObjectMeta.GetAnnotations()
 	inject, err := strconv.ParseBool(annotations[annotationKeySidecarInjection])
 	if err != nil {
@@ -153,21 +407,84 @@ func (w *Webhook) ignore(pod *corev1.Pod) bool {
 	return !inject
 }
 
-func (w *Webhook) sidecarFromConfigMap(name, namespace string, opt metav1.GetOptions) (*corev1.Container, error) {
-	configMap, err := w.Client.CoreV1().ConfigMaps(namespace).Get(name, opt)
-	if err != nil {
-		return nil, err
+// podName returns the name of pod, or its generate name if the name isn't assigned yet.
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
 	}
 
-	var c corev1.Container
-	if err := json.Unmarshal([]byte(configMap.Data["sidecar.json"]), &c); err != nil {
-		return nil, err
+	return pod.GenerateName
+}
+
+// respond sets the response of review to response, or to the error response of err. If the deadline of ctx expired, the response is decided by the failOpen policy instead, as the API server may have given up on the request.
+func (w *Webhook) respond(ctx context.Context, review *admissionv1beta1.AdmissionReview, response *admissionv1beta1.AdmissionResponse, err error, failOpen bool) {
+	switch {
+	case ctx.Err() != nil:
+		review.Response = w.deadlineResponse(review, ctx.Err(), failOpen)
+	case err != nil:
+		review.Response = errorResponse(review, err)
+	default:
+		review.Response = response
 	}
+}
+
+// DeadlineExceeded returns the admission review of the mutation request in data, with the response decided by the webhook's FailOpen policy. It's used when the request can't be handled before its deadline.
+func (w *Webhook) DeadlineExceeded(data []byte) *admissionv1beta1.AdmissionReview {
+	return w.deadlineExceeded(data, w.FailOpen)
+}
+
+// ValidationDeadlineExceeded returns the admission review of the validation request in data, with the response decided by the webhook's ValidationFailOpen policy. It's used when the request can't be handled before its deadline.
+func (w *Webhook) ValidationDeadlineExceeded(data []byte) *admissionv1beta1.AdmissionReview {
+	return w.deadlineExceeded(data, w.ValidationFailOpen)
+}
 
-	return &c, nil
+func (w *Webhook) deadlineExceeded(data []byte, failOpen bool) *admissionv1beta1.AdmissionReview {
+	admissionReview, _ := w.decode(context.Background(), data)
+	admissionReview.Response = w.deadlineResponse(admissionReview, context.DeadlineExceeded, failOpen)
+	return admissionReview
+}
+
+func (w *Webhook) deadlineResponse(review *admissionv1beta1.AdmissionReview, err error, failOpen bool) *admissionv1beta1.AdmissionResponse {
+	response := &admissionv1beta1.AdmissionResponse{}
+	if review.Request != nil {
+		response.UID = review.Request.UID
+	}
+
+	if failOpen {
+		w.logger.Warnf("Admission request %s wasn't handled before its deadline. Admitting it unchanged. Reason: %s", response.UID, err)
+		response.Allowed = true
+		return response
+	}
+
+	w.logger.Warnf("Admission request %s wasn't handled before its deadline. Rejecting it. Reason: %s", response.UID, err)
+	response.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Reason:  metav1.StatusReasonTimeout,
+		Message: fmt.Sprintf("Sidecar injector couldn't handle the request before its deadline: %s", err),
+	}
+	return response
+}
+
+func errorResponse(ar *admissionv1beta1.AdmissionReview, err error) *admissionv1beta1.AdmissionResponse {
+	response := &admissionv1beta1.AdmissionResponse{
+		Result: &metav1.Status{
+			Message: err.Error(),
+		},
+	}
+
+	if ar != nil && ar.Request != nil {
+		response.UID = ar.Request.UID
+	}
+
+	return response
 }
 
 // SetLogLevel sets the log level of the webhook's logger.
 func (w *Webhook) SetLogLevel(level logrus.Level) {
 	w.logger.SetLevel(level)
 }
+
+// SetLogFormatter sets the formatter of the webhook's logger.
+func (w *Webhook) SetLogFormatter(formatter logrus.Formatter) {
+	w.logger.Formatter = formatter
+}
@@ -1,8 +1,10 @@
 package injector
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -42,23 +44,97 @@ func TestMutate(t *testing.T) {
 		t.Fatal("Unexpected error: ", err)
 	}
 
-	actual := webhook.Mutate(data)
+	actual := webhook.Mutate(context.Background(), data)
 	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
 	}
 }
 
+func TestMutateDeadlineExceeded(t *testing.T) {
+	data, err := test.FixtureHTTPRequestBody("http-request-body-valid.json", ".")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	defer func() {
+		webhook.FailOpen = false
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var testCases = []struct {
+		failOpen bool
+		expected string
+	}{
+		{failOpen: true, expected: DecisionAllowed},
+		{failOpen: false, expected: DecisionError},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("fail-open=%t", testCase.failOpen), func(t *testing.T) {
+			webhook.FailOpen = testCase.failOpen
+
+			actual := webhook.Mutate(ctx, data)
+			if decision := Decision(actual); decision != testCase.expected {
+				t.Errorf("Decision mismatch. Expected: %s. Actual: %s", testCase.expected, decision)
+			}
+
+			if actual.Response.UID != actual.Request.UID || len(actual.Response.Patch) > 0 {
+				t.Errorf("Response mismatch. Actual: %+v", actual.Response)
+			}
+		})
+	}
+}
+
+func TestValidateDeadlineExceeded(t *testing.T) {
+	data, err := test.FixtureHTTPRequestBody("http-request-body-valid.json", ".")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	defer func() {
+		webhook.FailOpen = false
+		webhook.ValidationFailOpen = false
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the mutation fail-open policy doesn't apply to validation requests
+	var testCases = []struct {
+		failOpen           bool
+		validationFailOpen bool
+		expected           string
+	}{
+		{failOpen: true, validationFailOpen: false, expected: DecisionError},
+		{failOpen: false, validationFailOpen: true, expected: DecisionAllowed},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("fail-open=%t validation-fail-open=%t", testCase.failOpen, testCase.validationFailOpen), func(t *testing.T) {
+			webhook.FailOpen = testCase.failOpen
+			webhook.ValidationFailOpen = testCase.validationFailOpen
+
+			actual := webhook.Validate(ctx, data)
+			if decision := Decision(actual); decision != testCase.expected {
+				t.Errorf("Decision mismatch. Expected: %s. Actual: %s", testCase.expected, decision)
+			}
+		})
+	}
+}
+
 func TestDecode(t *testing.T) {
 	t.Run("With Nil Input", func(t *testing.T) {
-		actual, err := webhook.decode(nil)
+		actual, err := webhook.decode(context.Background(), nil)
 		if err != nil {
 			t.Fatal("Unexpected error", err)
 		}
 
 		expected := &admissionv1beta1.AdmissionReview{
-			metav1.TypeMeta{},
-			nil,
-			nil,
+			TypeMeta: metav1.TypeMeta{},
+			Request:  nil,
+			Response: nil,
 		}
 		if !reflect.DeepEqual(expected, actual) {
 			t.Errorf("Decoded content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
@@ -76,7 +152,7 @@ func TestDecode(t *testing.T) {
 			t.Fatal("Unexpected error: ", err)
 		}
 
-		actual, err := webhook.decode(in)
+		actual, err := webhook.decode(context.Background(), in)
 		if err != nil {
 			t.Fatal("Unexpected error: ", err)
 		}
@@ -92,7 +168,7 @@ func TestDecode(t *testing.T) {
 			t.Fatal("Unexpected error: ", err)
 		}
 
-		if _, err := webhook.decode(in); err == nil {
+		if _, err := webhook.decode(context.Background(), in); err == nil {
 			t.Error("Expected test to fail with malformed JSON error")
 		}
 	})
@@ -100,7 +176,7 @@ func TestDecode(t *testing.T) {
 
 func TestInject(t *testing.T) {
 	t.Run("With Nil input", func(t *testing.T) {
-		_, err := webhook.inject(nil)
+		_, err := webhook.inject(context.Background(), nil)
 		if err == nil {
 			t.Error("Expected error didn't occur")
 		}
@@ -121,7 +197,7 @@ func TestInject(t *testing.T) {
 			t.Fatal("Unexpected error: ", err)
 		}
 
-		actual, err := webhook.inject(admissionReview)
+		actual, err := webhook.inject(context.Background(), admissionReview)
 		if err != nil {
 			t.Fatal("Unexpected error: ", err)
 		}
@@ -131,6 +207,30 @@ func TestInject(t *testing.T) {
 		}
 	})
 
+	t.Run("With Image Policy Violation", func(t *testing.T) {
+		admissionReview, err := test.FixtureAdmissionReview("admission-review-request-only.json", ".")
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		fixture := *webhook
+		fixture.ImagePolicy = &ImagePolicy{AllowedRegistries: []string{"gcr.io"}}
+		if _, err := fixture.inject(context.Background(), admissionReview); err == nil {
+			t.Error("Expected error didn't occur")
+		}
+
+		digests, err := LoadDigestMapping(filepath.Join("test", "data", "image-digests.json"))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		// the sidecar image "nginx" is pinned by the "docker.io/library/nginx:latest" mapping
+		fixture.ImagePolicy = &ImagePolicy{RequireDigest: true, Digests: digests}
+		if _, err := fixture.inject(context.Background(), admissionReview); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	})
+
 	t.Run("With Valid Admission Review (ignore pod)", func(t *testing.T) {
 		admissionReview, err := test.FixtureAdmissionReview("admission-review-request-only-ignore-pod.json", ".")
 		if err != nil {
@@ -142,7 +242,7 @@ func TestInject(t *testing.T) {
 			t.Fatal("Unexpected error: ", err)
 		}
 
-		actual, err := webhook.inject(admissionReview)
+		actual, err := webhook.inject(context.Background(), admissionReview)
 		if err != nil {
 			t.Fatal("Unexpected error: ", err)
 		}
@@ -177,23 +277,6 @@ func TestIgnore(t *testing.T) {
 	}
 }
 
-func TestSidecarFromConfigMap(t *testing.T) {
-	expected, err := test.FixtureContainer(".", "sidecar-container.json")
-	if err != nil {
-		t.Fatal("Unexpected error: ", err)
-	}
-
-	opt := metav1.GetOptions{}
-	actual, err := webhook.sidecarFromConfigMap(configMapSidecar, defaultNamespace, opt)
-	if err != nil {
-		t.Fatal("Unexpected error: ", err)
-	}
-
-	if !reflect.DeepEqual(expected, actual) {
-		t.Errorf("Content mismatch\nExpected: %+v\nActual: %+v", expected, actual)
-	}
-}
-
 func initWebhookWithConfigMap() (*Webhook, error) {
 	fixture, err := New()
 	if err != nil {
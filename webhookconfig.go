@@ -0,0 +1,192 @@
+package injector
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	webhookNamePods      = "sidecar-injector.example.org"
+	webhookNameWorkloads = "workload-injector.example.org"
+)
+
+// WebhookConfigReconciler creates and patches the MutatingWebhookConfiguration of the webhook, so that its rules, selectors, failure policy, timeout and CA bundle match the capabilities of the running server. Webhooks that are added to the configuration by other clients are removed.
+type WebhookConfigReconciler struct {
+	webhook *Webhook
+	logger  *logrus.Logger
+
+	// Name is the name of the MutatingWebhookConfiguration.
+	Name string
+
+	// ServiceNamespace and ServiceName identify the service of the webhook server.
+	ServiceNamespace string
+	ServiceName      string
+
+	// PodsPath and WorkloadsPath are the paths of the pods and workloads mutating endpoints of the webhook server. The workloads webhook is only configured if the webhook's WorkloadMutation is enabled.
+	PodsPath      string
+	WorkloadsPath string
+
+	// CABundle is the PEM-encoded CA bundle that the API server uses to verify the webhook server's TLS cert.
+	CABundle []byte
+
+	// FailurePolicy defines how the API server handles errors from the webhook server.
+	FailurePolicy admissionregistrationv1beta1.FailurePolicyType
+
+	// SideEffects declares the side effects of the webhook to the API server.
+	SideEffects string
+
+	// Timeout is the duration that the API server waits for the webhook server to respond.
+	Timeout time.Duration
+
+	// NamespaceSelector and ObjectSelector limit the requests that are sent to the webhook server. They are merged with the selectors of the webhook's exclusions. Nil selectors match everything.
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+}
+
+// NewWebhookConfigReconciler returns a new instance of WebhookConfigReconciler that reconciles the MutatingWebhookConfiguration name, pointing at the serviceNamespace/serviceName service.
+func NewWebhookConfigReconciler(w *Webhook, name, serviceNamespace, serviceName string, caBundle []byte) *WebhookConfigReconciler {
+	return &WebhookConfigReconciler{
+		webhook:          w,
+		logger:           w.logger,
+		Name:             name,
+		ServiceNamespace: serviceNamespace,
+		ServiceName:      serviceName,
+		PodsPath:         "/mutate/pods",
+		WorkloadsPath:    "/mutate/workloads",
+		CABundle:         caBundle,
+		FailurePolicy:    admissionregistrationv1beta1.Ignore,
+		SideEffects:      "None",
+		Timeout:          10 * time.Second,
+	}
+}
+
+// Run reconciles the MutatingWebhookConfiguration on start and at every interval, until stopCh is closed.
+func (r *WebhookConfigReconciler) Run(interval time.Duration, stopCh <-chan struct{}) {
+	r.logger.Infof("Starting webhook configuration reconciler (name: %s, interval: %s)...", r.Name, interval)
+	wait.Until(func() {
+		if err := r.Reconcile(); err != nil {
+			r.logger.Errorf("Failed to reconcile MutatingWebhookConfiguration %s. Reason: %s", r.Name, err)
+		}
+	}, interval, stopCh)
+}
+
+// Reconcile creates the MutatingWebhookConfiguration if it doesn't exist, and patches it to the desired state. The fields that aren't supported by the admissionregistration/v1beta1 client types, such as sideEffects, timeoutSeconds and objectSelector, are set with a JSON merge patch.
+func (r *WebhookConfigReconciler) Reconcile() error {
+	webhooks := r.webhooks()
+	configs := r.webhook.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+
+	if _, err := configs.Get(r.Name, metav1.GetOptions{}); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		config := &admissionregistrationv1beta1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   r.Name,
+				Labels: map[string]string{"app": "sidecar-injector"},
+			},
+			Webhooks: webhooks,
+		}
+		if _, err := configs.Create(config); err != nil {
+			return err
+		}
+		r.logger.Infof("Created MutatingWebhookConfiguration %s", r.Name)
+	}
+
+	patch, err := r.patch(webhooks)
+	if err != nil {
+		return err
+	}
+
+	if _, err := configs.Patch(r.Name, types.MergePatchType, patch); err != nil {
+		return err
+	}
+	r.logger.Debugf("Patched MutatingWebhookConfiguration %s: %s", r.Name, patch)
+
+	return nil
+}
+
+// webhooks returns the desired webhooks of the configuration.
+func (r *WebhookConfigReconciler) webhooks() []admissionregistrationv1beta1.Webhook {
+	var (
+		createOnly     = []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create}
+		createOrUpdate = []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update}
+	)
+
+	webhooks := []admissionregistrationv1beta1.Webhook{
+		r.newWebhook(webhookNamePods, r.PodsPath, []admissionregistrationv1beta1.RuleWithOperations{
+			rule(createOnly, "", "v1", "pods"),
+		}),
+	}
+
+	if r.webhook.WorkloadMutation {
+		webhooks = append(webhooks, r.newWebhook(webhookNameWorkloads, r.WorkloadsPath, []admissionregistrationv1beta1.RuleWithOperations{
+			rule(createOrUpdate, "apps", "v1", "deployments", "statefulsets", "daemonsets"),
+			rule(createOrUpdate, "batch", "v1", "jobs"),
+			rule(createOrUpdate, "batch", "v1beta1", "cronjobs"),
+		}))
+	}
+
+	return webhooks
+}
+
+func (r *WebhookConfigReconciler) newWebhook(name, path string, rules []admissionregistrationv1beta1.RuleWithOperations) admissionregistrationv1beta1.Webhook {
+	failurePolicy := r.FailurePolicy
+	return admissionregistrationv1beta1.Webhook{
+		Name: name,
+		ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+			Service: &admissionregistrationv1beta1.ServiceReference{
+				Namespace: r.ServiceNamespace,
+				Name:      r.ServiceName,
+				Path:      &path,
+			},
+			CABundle: r.CABundle,
+		},
+		Rules:             rules,
+		FailurePolicy:     &failurePolicy,
+		NamespaceSelector: r.webhook.Exclusions.namespaceSelector(r.NamespaceSelector),
+	}
+}
+
+// patch returns the JSON merge patch that replaces the webhooks of the configuration with webhooks, extended with the fields that aren't supported by the client types.
+func (r *WebhookConfigReconciler) patch(webhooks []admissionregistrationv1beta1.Webhook) ([]byte, error) {
+	b, err := json.Marshal(webhooks)
+	if err != nil {
+		return nil, err
+	}
+
+	var extended []map[string]interface{}
+	if err := json.Unmarshal(b, &extended); err != nil {
+		return nil, err
+	}
+
+	for _, webhook := range extended {
+		webhook["sideEffects"] = r.SideEffects
+		webhook["timeoutSeconds"] = int32(r.Timeout / time.Second)
+		if objectSelector := r.webhook.Exclusions.objectSelector(r.ObjectSelector); objectSelector != nil {
+			webhook["objectSelector"] = objectSelector
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"webhooks": extended,
+	})
+}
+
+func rule(operations []admissionregistrationv1beta1.OperationType, apiGroup, apiVersion string, resources ...string) admissionregistrationv1beta1.RuleWithOperations {
+	return admissionregistrationv1beta1.RuleWithOperations{
+		Operations: operations,
+		Rule: admissionregistrationv1beta1.Rule{
+			APIGroups:   []string{apiGroup},
+			APIVersions: []string{apiVersion},
+			Resources:   resources,
+		},
+	}
+}
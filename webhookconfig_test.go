@@ -0,0 +1,158 @@
+package injector
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWebhookConfigReconciler(t *testing.T) {
+	var (
+		name     = "sidecar-injector-configuration"
+		caBundle = []byte("-----BEGIN CERTIFICATE-----")
+		selector = &metav1.LabelSelector{MatchLabels: map[string]string{"sidecar.example.org/inject": "enabled"}}
+	)
+
+	t.Run("Create", func(t *testing.T) {
+		w, err := initWebhookWithConfigMap()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		w.WorkloadMutation = true
+
+		reconciler := NewWebhookConfigReconciler(w, name, "default", "sidecar-injector", caBundle)
+		reconciler.NamespaceSelector = selector
+		expectedSelector := &metav1.LabelSelector{
+			MatchLabels: selector.MatchLabels,
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: labelKeyNamespaceExcluded, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"true"}},
+			},
+		}
+		if err := reconciler.Reconcile(); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		config, err := w.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(config.Webhooks) != 2 {
+			t.Fatalf("Webhooks mismatch. Expected: 2. Actual: %d", len(config.Webhooks))
+		}
+
+		expectedPaths := map[string]string{
+			webhookNamePods:      "/mutate/pods",
+			webhookNameWorkloads: "/mutate/workloads",
+		}
+		for _, webhook := range config.Webhooks {
+			if actual := *webhook.ClientConfig.Service.Path; actual != expectedPaths[webhook.Name] {
+				t.Errorf("Path mismatch of %s. Expected: %s. Actual: %s", webhook.Name, expectedPaths[webhook.Name], actual)
+			}
+
+			if !reflect.DeepEqual(caBundle, webhook.ClientConfig.CABundle) {
+				t.Errorf("CA bundle mismatch of %s\nExpected: %s\nActual: %s", webhook.Name, caBundle, webhook.ClientConfig.CABundle)
+			}
+
+			if !reflect.DeepEqual(expectedSelector, webhook.NamespaceSelector) {
+				t.Errorf("Namespace selector mismatch of %s\nExpected: %+v\nActual: %+v", webhook.Name, expectedSelector, webhook.NamespaceSelector)
+			}
+		}
+	})
+
+	t.Run("Patch Drifted Configuration", func(t *testing.T) {
+		w, err := initWebhookWithConfigMap()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		failurePolicy := admissionregistrationv1beta1.Fail
+		drifted := &admissionregistrationv1beta1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Webhooks: []admissionregistrationv1beta1.Webhook{
+				{
+					Name:          webhookNamePods,
+					ClientConfig:  admissionregistrationv1beta1.WebhookClientConfig{CABundle: []byte("expired")},
+					FailurePolicy: &failurePolicy,
+				},
+			},
+		}
+		if _, err := w.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Create(drifted); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		reconciler := NewWebhookConfigReconciler(w, name, "default", "sidecar-injector", caBundle)
+		reconciler.Timeout = 5 * time.Second
+		reconciler.ObjectSelector = selector
+		if err := reconciler.Reconcile(); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		config, err := w.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(config.Webhooks) != 1 {
+			t.Fatalf("Webhooks mismatch. Expected: 1. Actual: %d", len(config.Webhooks))
+		}
+
+		if actual := *config.Webhooks[0].FailurePolicy; actual != admissionregistrationv1beta1.Ignore {
+			t.Errorf("Failure policy mismatch. Expected: %s. Actual: %s", admissionregistrationv1beta1.Ignore, actual)
+		}
+
+		if !reflect.DeepEqual(caBundle, config.Webhooks[0].ClientConfig.CABundle) {
+			t.Errorf("CA bundle mismatch\nExpected: %s\nActual: %s", caBundle, config.Webhooks[0].ClientConfig.CABundle)
+		}
+	})
+
+	t.Run("Unsupported Fields", func(t *testing.T) {
+		reconciler := NewWebhookConfigReconciler(webhook, name, "default", "sidecar-injector", caBundle)
+		reconciler.Timeout = 5 * time.Second
+		reconciler.ObjectSelector = selector
+
+		b, err := reconciler.patch(reconciler.webhooks())
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		var patch struct {
+			Webhooks []struct {
+				Name           string                `json:"name"`
+				SideEffects    string                `json:"sideEffects"`
+				TimeoutSeconds int32                 `json:"timeoutSeconds"`
+				ObjectSelector *metav1.LabelSelector `json:"objectSelector"`
+			} `json:"webhooks"`
+		}
+		if err := json.Unmarshal(b, &patch); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(patch.Webhooks) != 1 {
+			t.Fatalf("Webhooks mismatch. Expected: 1. Actual: %d", len(patch.Webhooks))
+		}
+
+		actual := patch.Webhooks[0]
+		if actual.SideEffects != "None" {
+			t.Errorf("Side effects mismatch. Expected: None. Actual: %s", actual.SideEffects)
+		}
+
+		if actual.TimeoutSeconds != 5 {
+			t.Errorf("Timeout mismatch. Expected: 5. Actual: %d", actual.TimeoutSeconds)
+		}
+
+		expectedSelector := &metav1.LabelSelector{
+			MatchLabels: selector.MatchLabels,
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"sidecar-injector"}},
+			},
+		}
+		if !reflect.DeepEqual(expectedSelector, actual.ObjectSelector) {
+			t.Errorf("Object selector mismatch\nExpected: %+v\nActual: %+v", expectedSelector, actual.ObjectSelector)
+		}
+	})
+}
@@ -0,0 +1,332 @@
+package injector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	patchPrefixPodTemplate = "/spec/template"
+	patchPrefixJobTemplate = "/spec/jobTemplate/spec/template"
+
+	annotationKeySidecarHash = "sidecar.example.org/sidecar-hash"
+)
+
+// MutateWorkload changes the pod template of the workload controller defined in data by injecting the sidecar container spec into the template. Deployments, stateful sets, daemon sets, jobs and cron jobs are supported. If workload mutation isn't enabled, the workload controller is admitted unchanged, as the sidecar is injected into its pods when they are created.
+func (w *Webhook) MutateWorkload(ctx context.Context, data []byte) *admissionv1beta1.AdmissionReview {
+	ctx, span := w.Tracer.Start(ctx, "mutate-workload")
+	defer span.Finish()
+
+	admissionReview, err := w.decode(ctx, data)
+	defer traceOutcome(span, admissionReview)
+	if err != nil {
+		w.logger.Info("Failed to decode data. Reason: ", err)
+		admissionReview.Response = errorResponse(admissionReview, err)
+		return admissionReview
+	}
+	defer w.audit(admissionReview)
+
+	admissionResponse, err := w.injectWorkload(ctx, admissionReview)
+	span.RecordError(err)
+	w.respond(ctx, admissionReview, admissionResponse, err, w.FailOpen)
+
+	responseJSON, _ := json.Marshal(admissionReview.Response)
+	w.logger.Debugf("Admission response: %s", RedactJSON(responseJSON))
+
+	return admissionReview
+}
+
+func (w *Webhook) injectWorkload(ctx context.Context, ar *admissionv1beta1.AdmissionReview) (*admissionv1beta1.AdmissionResponse, error) {
+	if ar == nil || ar.Request == nil {
+		return nil, errNilAdmissionReviewInput
+	}
+
+	request := ar.Request
+	if !w.WorkloadMutation {
+		return &admissionv1beta1.AdmissionResponse{
+			UID:     request.UID,
+			Allowed: true,
+		}, nil
+	}
+
+	template, prefix, err := podTemplate(request.Kind, request.Object.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	podPatch := NewPodTemplatePatch(template, prefix)
+	podPatch.original.Namespace = request.Namespace
+	if podPatch.raw, err = rawTemplate(request.Object.Raw, prefix); err != nil {
+		return nil, err
+	}
+	if reason := w.Exclusions.excluded(podPatch.original); reason != "" {
+		w.logger.Debugf("Ignoring %s %s/%s: %s", request.Kind.Kind, request.Namespace, request.Name, reason)
+		return &admissionv1beta1.AdmissionResponse{
+			UID:     request.UID,
+			Allowed: true,
+		}, nil
+	}
+
+	if request.Operation == admissionv1beta1.Update {
+		// the pod template of a job is immutable, unlike the job template of a cron job
+		if request.Kind.Group == "batch" && request.Kind.Kind == "Job" {
+			return &admissionv1beta1.AdmissionResponse{
+				UID:     request.UID,
+				Allowed: true,
+			}, nil
+		}
+
+		return w.upgradeWorkload(ctx, request, podPatch)
+	}
+
+	return w.injectPodSpec(ctx, request.UID, podPatch)
+}
+
+// upgradeWorkload returns the admission response of an update to a workload controller. If the pod template was injected with an older version of the sidecar template, the sidecar container is upgraded to the current version, unless the sidecar container was edited since it was injected. User edits to the sidecar container are never reverted.
+func (w *Webhook) upgradeWorkload(ctx context.Context, request *admissionv1beta1.AdmissionRequest, podPatch *PodPatch) (*admissionv1beta1.AdmissionResponse, error) {
+	allowed := &admissionv1beta1.AdmissionResponse{
+		UID:     request.UID,
+		Allowed: true,
+	}
+
+	pod := podPatch.original
+	annotations := pod.ObjectMeta.GetAnnotations()
+	injectedVersion, versioned := annotations[annotationKeySidecarVersion]
+	if !versioned && annotations[annotationKeySidecarInjection] != "false" {
+		// the pod template was never injected, so handle it like a new workload
+		return w.injectPodSpec(ctx, request.UID, podPatch)
+	}
+
+	sidecar, template, version, err := w.sidecar(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.Overlay != nil {
+		return w.upgradeOverlay(ctx, request, podPatch, template, version)
+	}
+
+	// a renamed sidecar container keeps its name
+	sidecar.Name = sidecarName(pod, sidecar.Name)
+	index := containerIndex(pod.Spec.Containers, sidecar.Name)
+	if index == -1 {
+		// the sidecar container was removed by the user, or the pod template opted out of the injection
+		return allowed, nil
+	}
+
+	currentHash, err := sidecarHash(&pod.Spec.Containers[index])
+	if err != nil {
+		return nil, err
+	}
+
+	upgradedHash, err := sidecarHash(sidecar)
+	if err != nil {
+		return nil, err
+	}
+
+	injectedHash, hashed := annotations[annotationKeySidecarHash]
+	if !hashed {
+		// the pod template was injected before the sidecar hash was recorded, so the sidecar is adopted only if it's known to be unedited
+		if currentHash != upgradedHash {
+			w.logger.Infof("Skipping upgrade of sidecar container %q to template version %s. The sidecar was injected without a sidecar hash, so it can't be told apart from a user edit", sidecar.Name, version)
+			return allowed, nil
+		}
+
+		w.logger.Debugf("Adopting sidecar container %q injected without a sidecar hash", sidecar.Name)
+		podPatch.addAnnotationPatch(version, currentHash)
+		return patchResponse(request.UID, podPatch)
+	}
+
+	if injectedVersion == version {
+		w.logger.Debugf("Sidecar is up-to-date with template version %s", version)
+		return allowed, nil
+	}
+
+	if currentHash != injectedHash {
+		w.logger.Debugf("Sidecar container %q was edited since it was injected. Skipping upgrade from template version %s to %s", sidecar.Name, injectedVersion, version)
+		return allowed, nil
+	}
+
+	others := pod.DeepCopy()
+	others.Spec.Containers = append(others.Spec.Containers[:index], others.Spec.Containers[index+1:]...)
+	if conflicts := conflicts(others, sidecar); len(conflicts) > 0 {
+		w.logger.Infof("Skipping upgrade from template version %s to %s. Sidecar container %q conflicts with the pod template: %s", injectedVersion, version, sidecar.Name, conflictMessage(conflicts))
+		return allowed, nil
+	}
+
+	w.logger.Debugf("Upgrading sidecar from template version %s to %s", injectedVersion, version)
+	_, span := w.Tracer.Start(ctx, "patch")
+	defer span.Finish()
+	span.SetAttribute("template.previous_version", injectedVersion)
+
+	podPatch.addShutdownPatch(template.Shutdown, sidecar.Name)
+	if template.holdsApplication() && index != 0 {
+		// the sidecar is moved before the application containers
+		podPatch.addContainerRemovePatch(index)
+		podPatch.addFirstContainerPatch(sidecar)
+	} else {
+		podPatch.addContainerReplacePatch(index, sidecar)
+	}
+	podPatch.addAnnotationPatch(version, upgradedHash)
+	podPatch.addPodMutationsPatch(template.Pod)
+
+	return patchResponse(request.UID, podPatch)
+}
+
+// upgradeOverlay returns the admission response of an update to a workload controller whose pod template was injected with the overlay of an older version of the sidecar template. The upgrade is skipped if the containers, init containers or volumes of the injected overlay were edited since the injection, in this update or in an earlier one. The other items of the pod spec can be edited freely.
+func (w *Webhook) upgradeOverlay(ctx context.Context, request *admissionv1beta1.AdmissionRequest, podPatch *PodPatch, template *SidecarTemplate, version string) (*admissionv1beta1.AdmissionResponse, error) {
+	allowed := &admissionv1beta1.AdmissionResponse{
+		UID:     request.UID,
+		Allowed: true,
+	}
+
+	pod := podPatch.original
+	annotations := pod.ObjectMeta.GetAnnotations()
+	injectedVersion := annotations[annotationKeySidecarVersion]
+
+	injectedHash, hashed := annotations[annotationKeySidecarHash]
+	items := parseOverlayItems(annotations)
+	if !hashed || items == nil {
+		// the pod template was injected before the overlay hash was recorded, so it's adopted only if the current overlay leaves it unchanged
+		overlay, err := w.overlay(template)
+		if err != nil {
+			return nil, err
+		}
+
+		merged := &PodPatch{original: podPatch.original, raw: podPatch.raw, prefix: podPatch.prefix, patchOps: []*patchOp{}}
+		if _, err := merged.addOverlayPatch(overlay); err != nil {
+			return nil, fmt.Errorf("Failed to merge the sidecar overlay: %s", err)
+		}
+
+		if len(merged.patchOps) > 0 {
+			w.logger.Infof("Skipping upgrade of the overlay to template version %s. The overlay was injected without an overlay hash, so it can't be told apart from a user edit", version)
+			return allowed, nil
+		}
+
+		items = newOverlayItems(overlay)
+		currentHash, err := overlayHash(&pod.Spec, items)
+		if err != nil {
+			return nil, err
+		}
+
+		w.logger.Debug("Adopting overlay injected without an overlay hash")
+		podPatch.addAnnotationPatch(version, currentHash)
+		podPatch.addAnnotationsPatch(map[string]string{annotationKeyOverlayItems: items.String()})
+		return patchResponse(request.UID, podPatch)
+	}
+
+	// the hash covers the items of the injected overlay, which can differ from the items of the current overlay
+	currentHash, err := overlayHash(&pod.Spec, items)
+	if err != nil {
+		return nil, err
+	}
+
+	if injectedVersion == version {
+		w.logger.Debugf("Sidecar is up-to-date with template version %s", version)
+		return allowed, nil
+	}
+
+	if currentHash != injectedHash {
+		w.logger.Debugf("Overlay items were edited since the overlay was injected. Skipping upgrade from template version %s to %s", injectedVersion, version)
+		return allowed, nil
+	}
+
+	w.logger.Debugf("Upgrading overlay from template version %s to %s", injectedVersion, version)
+	return w.overlayPatch(ctx, request.UID, podPatch, template, version)
+}
+
+// sidecarHash returns the hash of the sidecar container, without the fields that the API server defaults. The hash of the injected container matches the hash of the container stored in the pod template, until the container is edited.
+func sidecarHash(sidecar *corev1.Container) (string, error) {
+	return hashJSON(withoutDefaults(sidecar))
+}
+
+// withoutDefaults returns a normalized copy of container, without the fields that the API server defaults.
+func withoutDefaults(container *corev1.Container) *corev1.Container {
+	hashed := normalizeContainer(container)
+	hashed.TerminationMessagePath = ""
+	hashed.TerminationMessagePolicy = ""
+	hashed.ImagePullPolicy = ""
+	hashed.LivenessProbe = nil
+	hashed.ReadinessProbe = nil
+
+	if hashed.Lifecycle != nil {
+		for _, handler := range []*corev1.Handler{hashed.Lifecycle.PostStart, hashed.Lifecycle.PreStop} {
+			if handler != nil && handler.HTTPGet != nil {
+				handler.HTTPGet.Path, handler.HTTPGet.Scheme = "", ""
+			}
+		}
+	}
+
+	return hashed
+}
+
+// hashJSON returns the truncated SHA-256 hash of the JSON of value.
+func hashJSON(value interface{}) (string, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:16], nil
+}
+
+func containerIndex(containers []corev1.Container, name string) int {
+	for i, container := range containers {
+		if container.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// podTemplate returns the pod template of the workload controller of the given kind, and the path of the template within the controller.
+func podTemplate(kind metav1.GroupVersionKind, raw []byte) (*corev1.PodTemplateSpec, string, error) {
+	switch kind {
+	case metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}:
+		var deployment appsv1.Deployment
+		if err := json.Unmarshal(raw, &deployment); err != nil {
+			return nil, "", err
+		}
+		return &deployment.Spec.Template, patchPrefixPodTemplate, nil
+
+	case metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}:
+		var statefulSet appsv1.StatefulSet
+		if err := json.Unmarshal(raw, &statefulSet); err != nil {
+			return nil, "", err
+		}
+		return &statefulSet.Spec.Template, patchPrefixPodTemplate, nil
+
+	case metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}:
+		var daemonSet appsv1.DaemonSet
+		if err := json.Unmarshal(raw, &daemonSet); err != nil {
+			return nil, "", err
+		}
+		return &daemonSet.Spec.Template, patchPrefixPodTemplate, nil
+
+	case metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}:
+		var job batchv1.Job
+		if err := json.Unmarshal(raw, &job); err != nil {
+			return nil, "", err
+		}
+		return &job.Spec.Template, patchPrefixPodTemplate, nil
+
+	case metav1.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"}:
+		var cronJob batchv1beta1.CronJob
+		if err := json.Unmarshal(raw, &cronJob); err != nil {
+			return nil, "", err
+		}
+		return &cronJob.Spec.JobTemplate.Spec.Template, patchPrefixJobTemplate, nil
+	}
+
+	return nil, "", fmt.Errorf("Unsupported workload kind %s", kind.String())
+}
@@ -0,0 +1,255 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ihcsim/sidecar-injector/test"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestInjectWorkload(t *testing.T) {
+	var testCases = []string{"deployment", "statefulset", "daemonset", "job", "cronjob"}
+
+	t.Run("With Workload Mutation Disabled", func(t *testing.T) {
+		admissionReview, err := test.FixtureAdmissionReview("admission-review-request-deployment.json", ".")
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		actual, err := webhook.injectWorkload(context.Background(), admissionReview)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !actual.Allowed || actual.Patch != nil {
+			t.Errorf("Expected workload to be admitted unchanged. Actual: %+v", actual)
+		}
+	})
+
+	webhook.WorkloadMutation = true
+	defer func() {
+		webhook.WorkloadMutation = false
+	}()
+
+	for _, kind := range testCases {
+		t.Run(kind, func(t *testing.T) {
+			admissionReview, err := test.FixtureAdmissionReview(fmt.Sprintf("admission-review-request-%s.json", kind), ".")
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			expected, err := test.FixtureAdmissionResponse(".", fmt.Sprintf("admission-response-%s.json", kind))
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := webhook.injectWorkload(context.Background(), admissionReview)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Mismatch content\nExpected: %s\nActual: %s", expected.Patch, actual.Patch)
+			}
+		})
+	}
+
+	t.Run("With Unsupported Kind", func(t *testing.T) {
+		admissionReview, err := test.FixtureAdmissionReview("admission-review-request-only.json", ".")
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if _, err := webhook.injectWorkload(context.Background(), admissionReview); err == nil {
+			t.Error("Expected error didn't occur")
+		}
+	})
+}
+
+func TestUpgradeWorkload(t *testing.T) {
+	webhook.WorkloadMutation = true
+	defer func() {
+		webhook.WorkloadMutation = false
+	}()
+
+	sidecar, err := test.FixtureContainer(".", "sidecar-container.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	upgradeOps, err := json.Marshal([]*patchOp{
+		&patchOp{Op: "replace", Path: "/spec/template/spec/containers/1", Value: sidecar},
+		&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1inject", Value: "false"},
+		&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1sidecar-hash", Value: "ce9b44a41ecd66d6"},
+		&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1template-version", Value: "d89fb08ff4fbd920"},
+	})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var testCases = []struct {
+		name     string
+		expected []byte
+	}{
+		{name: "outdated", expected: upgradeOps},
+		{name: "up-to-date", expected: nil},
+		{name: "user-edited", expected: nil},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			admissionReview, err := test.FixtureAdmissionReview(fmt.Sprintf("admission-review-update-deployment-%s.json", testCase.name), ".")
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			actual, err := webhook.injectWorkload(context.Background(), admissionReview)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if !actual.Allowed {
+				t.Errorf("Expected workload update to be allowed. Actual: %+v", actual)
+			}
+
+			if !reflect.DeepEqual(actual.Patch, testCase.expected) {
+				t.Errorf("Patch mismatch\nExpected: %s\nActual: %s", testCase.expected, actual.Patch)
+			}
+		})
+	}
+
+	t.Run("user-edited in an earlier update", func(t *testing.T) {
+		// the first update edits the sidecar image
+		admissionReview, err := test.FixtureAdmissionReview("admission-review-update-deployment-user-edited.json", ".")
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		actual, err := webhook.injectWorkload(context.Background(), admissionReview)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !actual.Allowed || actual.Patch != nil {
+			t.Fatalf("Expected the first update to be admitted unchanged. Actual: %+v", actual)
+		}
+
+		// the second update only changes the application image
+		admissionReview.Request.OldObject.Raw = admissionReview.Request.Object.Raw
+		if admissionReview.Request.Object.Raw, err = editDeployment(admissionReview.Request.Object.Raw, func(deployment *appsv1.Deployment) {
+			deployment.Spec.Template.Spec.Containers[0].Image = "busybox:1.30"
+		}); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		actual, err = webhook.injectWorkload(context.Background(), admissionReview)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if !actual.Allowed || actual.Patch != nil {
+			t.Errorf("Expected the second update to be admitted unchanged. Actual: %s", actual.Patch)
+		}
+	})
+
+	t.Run("injected without a sidecar hash", func(t *testing.T) {
+		adoptOps, err := json.Marshal([]*patchOp{
+			&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1inject", Value: "false"},
+			&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1sidecar-hash", Value: "ce9b44a41ecd66d6"},
+			&patchOp{Op: "add", Path: "/spec/template/metadata/annotations/sidecar.example.org~1template-version", Value: "d89fb08ff4fbd920"},
+		})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		var testCases = []struct {
+			name     string
+			filename string
+			expected []byte
+		}{
+			{name: "matching sidecar is adopted", filename: "admission-review-update-deployment-up-to-date.json", expected: adoptOps},
+			{name: "outdated sidecar is skipped", filename: "admission-review-update-deployment-outdated.json", expected: nil},
+		}
+
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				admissionReview, err := test.FixtureAdmissionReview(testCase.filename, ".")
+				if err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+
+				// the pod template was injected before the template version and the sidecar hash were recorded
+				legacy := func(deployment *appsv1.Deployment) {
+					delete(deployment.Spec.Template.ObjectMeta.Annotations, annotationKeySidecarVersion)
+					delete(deployment.Spec.Template.ObjectMeta.Annotations, annotationKeySidecarHash)
+				}
+				for _, raw := range []*[]byte{&admissionReview.Request.Object.Raw, &admissionReview.Request.OldObject.Raw} {
+					if *raw, err = editDeployment(*raw, legacy); err != nil {
+						t.Fatal("Unexpected error: ", err)
+					}
+				}
+
+				actual, err := webhook.injectWorkload(context.Background(), admissionReview)
+				if err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+
+				if !actual.Allowed {
+					t.Errorf("Expected workload update to be allowed. Actual: %+v", actual)
+				}
+
+				if !reflect.DeepEqual(actual.Patch, testCase.expected) {
+					t.Errorf("Patch mismatch\nExpected: %s\nActual: %s", testCase.expected, actual.Patch)
+				}
+			})
+		}
+	})
+
+	var kindCases = []struct {
+		kind    string
+		patched bool
+	}{
+		{kind: "job", patched: false},
+		{kind: "cronjob", patched: true},
+	}
+
+	for _, kindCase := range kindCases {
+		t.Run(kindCase.kind, func(t *testing.T) {
+			admissionReview, err := test.FixtureAdmissionReview(fmt.Sprintf("admission-review-request-%s.json", kindCase.kind), ".")
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			admissionReview.Request.Operation = admissionv1beta1.Update
+			admissionReview.Request.OldObject = admissionReview.Request.Object
+
+			actual, err := webhook.injectWorkload(context.Background(), admissionReview)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			if !actual.Allowed {
+				t.Errorf("Expected workload update to be allowed. Actual: %+v", actual)
+			}
+
+			if patched := actual.Patch != nil; patched != kindCase.patched {
+				t.Errorf("Patch mismatch. Expected patched: %t. Actual: %s", kindCase.patched, actual.Patch)
+			}
+		})
+	}
+}
+
+// editDeployment returns the raw JSON of the deployment in raw, after it's changed by edit.
+func editDeployment(raw []byte, edit func(*appsv1.Deployment)) ([]byte, error) {
+	var deployment appsv1.Deployment
+	if err := json.Unmarshal(raw, &deployment); err != nil {
+		return nil, err
+	}
+
+	edit(&deployment)
+	return json.Marshal(&deployment)
+}